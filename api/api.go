@@ -13,12 +13,69 @@ func SetUpRouter(h *handlers.Handler, log logger.LoggerI) *chi.Mux {
 	router.Use(handlers.NewMWLogger(log))
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.URLFormat)
-	router.Use(handlers.AuthMiddlewareUserID)
 
-	router.Head("/api/v1/wallets", h.DoesWalletExists)
-	router.Post("/api/v1/wallets", h.PutFunds())
-	router.Get("/api/v1/wallets/stats", h.GetStats)
-	router.Get("/api/v1/wallets/balance", h.GetBalance)
+	router.Group(func(r chi.Router) {
+		r.Use(h.AuthMiddlewarePartner)
+		r.Use(handlers.AuthMiddlewareUserID)
+
+		// every route is signed: POSTs over their raw body, HEAD and GET
+		// over method, path and query
+		signed := r.With(h.VerifySignature)
+
+		signed.Head("/api/v1/wallets", h.DoesWalletExists)
+		signed.Get("/api/v1/wallets", h.ListWallets)
+		signed.Get("/api/v1/wallets/stats", h.GetStats)
+		signed.Get("/api/v1/wallets/balance", h.GetBalance)
+		signed.Get("/api/v1/wallets/transactions", h.ListTransactions)
+		signed.Get("/api/v1/wallets/identification", h.GetIdentification)
+
+		signed.Post("/api/v1/wallets/create", h.CreateWallet())
+		signed.With(h.Idempotency).Post("/api/v1/wallets", h.PutFunds())
+		signed.With(h.Idempotency).Post("/api/v1/wallets/withdraw", h.Withdraw())
+		signed.With(h.Idempotency).Post("/api/v1/wallets/transfer", h.Transfer())
+		signed.With(h.Idempotency).Post("/api/v1/wallets/reversal", h.Reverse())
+		signed.Post("/api/v1/wallets/identification", h.SubmitIdentification())
+
+		// the same operations on a wallet addressed by its public id; the
+		// routes above work with the user's default wallet
+		signed.Route("/api/v1/wallets/{walletID}", func(r chi.Router) {
+			r.Head("/", h.DoesWalletExists)
+			r.Get("/stats", h.GetStats)
+			r.Get("/balance", h.GetBalance)
+			r.Get("/transactions", h.ListTransactions)
+			r.Get("/identification", h.GetIdentification)
+
+			r.With(h.Idempotency).Post("/", h.PutFunds())
+			r.With(h.Idempotency).Post("/withdraw", h.Withdraw())
+			r.With(h.Idempotency).Post("/transfer", h.Transfer())
+			r.With(h.Idempotency).Post("/reversal", h.Reverse())
+			r.Post("/identification", h.SubmitIdentification())
+			r.Post("/default", h.SetDefaultWallet)
+		})
+	})
+
+	router.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(h.AuthMiddlewareAdmin)
+
+		r.Get("/identifications", h.ListIdentifications)
+		r.Post("/identifications/{id}/approve", h.ApproveIdentification)
+		r.Post("/identifications/{id}/reject", h.RejectIdentification())
+
+		r.Get("/partners/{partnerID}/keys", h.ListPartnerKeys)
+		r.Post("/partners/{partnerID}/keys", h.CreatePartnerKey())
+		r.Post("/partners/{partnerID}/keys/{keyID}/revoke", h.RevokePartnerKey)
+
+		r.Post("/wallets/{walletID}/status", h.ChangeWalletStatus())
+		r.Get("/wallets/{walletID}/limits", h.GetWalletLimits)
+		r.Post("/wallets/{walletID}/limits", h.UpdateWalletLimits())
+		r.Delete("/wallets/{walletID}/limits/{rule}", h.DeleteWalletLimit)
+
+		r.Get("/wallet-types", h.ListWalletTypes)
+		r.Post("/wallet-types", h.CreateWalletType())
+		r.Post("/wallet-types/{id}", h.UpdateWalletType())
+
+		r.Get("/ledger/check", h.CheckLedger)
+	})
 
 	return router
 }
@@ -3,8 +3,11 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/parviz-yu/digital-wallet/internal/config"
@@ -12,12 +15,19 @@ import (
 	"github.com/parviz-yu/digital-wallet/internal/service"
 	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
 	"github.com/parviz-yu/digital-wallet/pkg/logger"
-	"github.com/parviz-yu/digital-wallet/pkg/security"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
 )
 
+const maxWalletNameLength = 100
+
 var (
-	ErrInvalidReqBody = errors.New("invalid request body")
-	ErrInvalidAmount  = errors.New("invalid  amount")
+	ErrInvalidReqBody  = errors.New("invalid request body")
+	ErrInvalidAmount   = errors.New("invalid  amount")
+	ErrNoReceiver      = errors.New("to_user_id or to_wallet_id required")
+	ErrInvalidType     = errors.New("invalid wallet type")
+	ErrInvalidUserID   = errors.New("invalid X-UserId header value")
+	ErrInvalidName     = errors.New("invalid wallet name")
+	ErrInvalidCurrency = errors.New("unknown currency")
 )
 
 type Handler struct {
@@ -44,7 +54,7 @@ func (h *Handler) DoesWalletExists(w http.ResponseWriter, r *http.Request) {
 	)
 
 	userID := r.Context().Value(ctxKeyUserID).(string)
-	_, err := h.svc.DoesWalletExists(r.Context(), userID)
+	_, err := h.svc.DoesWalletExists(r.Context(), walletOwner(r))
 	if errors.Is(err, customerrors.ErrWalletNotFound) {
 		log.Warn(err.Error(), logger.String("X-UserID", userID))
 
@@ -61,13 +71,15 @@ func (h *Handler) DoesWalletExists(w http.ResponseWriter, r *http.Request) {
 	Respond(w, r, http.StatusOK, nil)
 }
 
-func (h *Handler) PutFunds() http.HandlerFunc {
+func (h *Handler) CreateWallet() http.HandlerFunc {
 	type request struct {
-		Amount float64 `json:"amount"`
+		Type     int    `json:"type"`
+		Name     string `json:"name"`
+		Currency string `json:"currency"` // TJS if empty
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		const fn = "handlers.PutFunds"
+		const fn = "handlers.CreateWallet"
 
 		log := logger.With(
 			h.log,
@@ -76,11 +88,10 @@ func (h *Handler) PutFunds() http.HandlerFunc {
 		)
 
 		userID := r.Context().Value(ctxKeyUserID).(string)
-		digest := r.Header.Get(digestHeader)
-		if digest == "" {
-			log.Warn(ErrNoXDigestHeader.Error(), logger.String("X-UserID", userID))
+		if len(userID) != userIDLength {
+			log.Warn(ErrInvalidUserID.Error(), logger.String("X-UserID", userID))
 
-			Error(w, r, http.StatusUnauthorized, ErrNoXDigestHeader)
+			Error(w, r, http.StatusBadRequest, ErrInvalidUserID)
 			return
 		}
 
@@ -95,42 +106,247 @@ func (h *Handler) PutFunds() http.HandlerFunc {
 		}
 		defer r.Body.Close()
 
-		if req.Amount < 1 {
+		if req.Type < 1 {
+			log.Warn(ErrInvalidType.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidType)
+			return
+		}
+
+		if utf8.RuneCountInString(req.Name) > maxWalletNameLength {
+			log.Warn(ErrInvalidName.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidName)
+			return
+		}
+
+		if req.Currency == "" {
+			req.Currency = money.TJS
+		}
+		if !validCurrency(req.Currency) {
+			log.Warn(ErrInvalidCurrency.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidCurrency)
+			return
+		}
+
+		walletReq := models.CreateWalletReq{
+			Owner:    walletOwner(r),
+			Type:     req.Type,
+			Name:     strings.TrimSpace(req.Name),
+			Currency: req.Currency,
+		}
+
+		resp, err := h.svc.CreateWallet(r.Context(), &walletReq)
+		if errors.Is(err, customerrors.ErrWalletTypeNotFound) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, customerrors.ErrWalletTypeNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrCurrencyNotSupported) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusUnprocessableEntity, customerrors.ErrCurrencyNotSupported)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		log.Info("wallet created",
+			logger.String("X-UserID", userID),
+			logger.Int("type", req.Type),
+			logger.String("currency", req.Currency),
+		)
+
+		Respond(w, r, http.StatusCreated, resp)
+	}
+}
+
+func (h *Handler) PutFunds() http.HandlerFunc {
+	type request struct {
+		Amount   money.Decimal `json:"amount"`
+		Currency string        `json:"currency"` // the wallet's currency if empty
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.PutFunds"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userID := r.Context().Value(ctxKeyUserID).(string)
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, decodeError(err))
+			return
+		}
+		defer r.Body.Close()
+
+		if !req.Amount.Positive() {
 			log.Warn("negative amount", logger.String("X-UserID", userID))
 
 			Error(w, r, http.StatusBadRequest, ErrInvalidAmount)
 			return
 		}
 
-		reqBody, err := json.Marshal(req)
+		if !validCurrency(req.Currency) {
+			log.Warn(ErrInvalidCurrency.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidCurrency)
+			return
+		}
+
+		paymentReq := models.PaymentReq{
+			Owner:          walletOwner(r),
+			Amount:         req.Amount,
+			Currency:       req.Currency,
+			IdempotencyKey: idempotencyKey(r),
+		}
+
+		err := h.svc.PutFunds(r.Context(), &paymentReq)
+		if code, statusErr := walletStatusError(err); statusErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, statusErr)
+			return
+		}
+		if replayCompleted(w, r, err) {
+			log.Info("replaying stored response", logger.String("X-UserID", userID))
+			return
+		}
+		if code, idemErr := idempotencyError(err); idemErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, idemErr)
+			return
+		}
+		if code, amountErr := amountError(err); amountErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, amountErr)
+			return
+		}
+		var ruleErr customerrors.ErrRuleViolated
+		if errors.As(err, &ruleErr) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			RuleViolation(w, r, ruleErr)
+			return
+		}
+
+		if errors.Is(err, customerrors.ErrWalletNotFound) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusNotFound, nil)
+			return
+		}
 		if err != nil {
-			log.Error(err.Error(), logger.String("X-UserID", userID), logger.Any("reqBody", req))
+			log.Error(err.Error(), logger.String("X-UserID", userID))
 
 			Error(w, r, http.StatusInternalServerError, nil)
 			return
 		}
 
-		if !security.VerifyBody(h.cfg.SecretToket, reqBody, digest) {
-			log.Warn(ErrInvalidXDigestHeader.Error(), logger.String("X-UserID", userID))
+		Respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (h *Handler) Withdraw() http.HandlerFunc {
+	type request struct {
+		Amount   money.Decimal `json:"amount"`
+		Currency string        `json:"currency"` // the wallet's currency if empty
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.Withdraw"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userID := r.Context().Value(ctxKeyUserID).(string)
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, decodeError(err))
+			return
+		}
+		defer r.Body.Close()
+
+		if !req.Amount.Positive() {
+			log.Warn("negative amount", logger.String("X-UserID", userID))
 
-			Error(w, r, http.StatusUnauthorized, ErrInvalidXDigestHeader)
+			Error(w, r, http.StatusBadRequest, ErrInvalidAmount)
+			return
+		}
+
+		if !validCurrency(req.Currency) {
+			log.Warn(ErrInvalidCurrency.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidCurrency)
 			return
 		}
 
 		paymentReq := models.PaymentReq{
-			UserID: userID,
-			Amount: req.Amount,
+			Owner:          walletOwner(r),
+			Amount:         req.Amount,
+			Currency:       req.Currency,
+			IdempotencyKey: idempotencyKey(r),
+		}
+
+		err := h.svc.Withdraw(r.Context(), &paymentReq)
+		if code, statusErr := walletStatusError(err); statusErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, statusErr)
+			return
+		}
+		if replayCompleted(w, r, err) {
+			log.Info("replaying stored response", logger.String("X-UserID", userID))
+			return
+		}
+		if code, idemErr := idempotencyError(err); idemErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, idemErr)
+			return
 		}
+		if code, amountErr := amountError(err); amountErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
 
-		err = h.svc.PutFunds(r.Context(), &paymentReq)
-		var customErr customerrors.ErrLimitExceeded
+			Error(w, r, code, amountErr)
+			return
+		}
+		var customErr customerrors.ErrInsufficientFunds
 		if errors.As(err, &customErr) {
 			log.Warn(err.Error(), logger.String("X-UserID", userID))
-			Error(
-				w,
-				r,
-				http.StatusOK,
-				fmt.Errorf("limit exceeded, for %s is %d TJS", customErr.WalletType, customErr.MaxAmount/100))
+
+			Error(w, r, http.StatusUnprocessableEntity, customErr)
+			return
+		}
+
+		var ruleErr customerrors.ErrRuleViolated
+		if errors.As(err, &ruleErr) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			RuleViolation(w, r, ruleErr)
 			return
 		}
 
@@ -151,6 +367,142 @@ func (h *Handler) PutFunds() http.HandlerFunc {
 	}
 }
 
+func (h *Handler) Transfer() http.HandlerFunc {
+	type request struct {
+		ToUserID   string        `json:"to_user_id"`
+		ToWalletID string        `json:"to_wallet_id"` // the receiver's default wallet if empty
+		Amount     money.Decimal `json:"amount"`
+		Currency   string        `json:"currency"` // the wallet's currency if empty
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.Transfer"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userID := r.Context().Value(ctxKeyUserID).(string)
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, decodeError(err))
+			return
+		}
+		defer r.Body.Close()
+
+		// a transfer with only to_wallet_id moves money between own wallets
+		if req.ToUserID == "" && req.ToWalletID == "" {
+			log.Warn(ErrNoReceiver.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrNoReceiver)
+			return
+		}
+		if req.ToUserID == "" {
+			req.ToUserID = userID
+		}
+
+		if !req.Amount.Positive() {
+			log.Warn("negative amount", logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidAmount)
+			return
+		}
+
+		if !validCurrency(req.Currency) {
+			log.Warn(ErrInvalidCurrency.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidCurrency)
+			return
+		}
+
+		sender := walletOwner(r)
+		transferReq := models.TransferReq{
+			From: sender,
+			To: models.WalletOwner{
+				PartnerID: sender.PartnerID,
+				UserID:    req.ToUserID,
+				WalletID:  req.ToWalletID,
+			},
+			Amount:         req.Amount,
+			Currency:       req.Currency,
+			IdempotencyKey: idempotencyKey(r),
+		}
+
+		resp, err := h.svc.Transfer(r.Context(), &transferReq)
+		if code, statusErr := walletStatusError(err); statusErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, statusErr)
+			return
+		}
+		if replayCompleted(w, r, err) {
+			log.Info("replaying stored response", logger.String("X-UserID", userID))
+			return
+		}
+		if code, idemErr := idempotencyError(err); idemErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, idemErr)
+			return
+		}
+		if code, amountErr := amountError(err); amountErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, amountErr)
+			return
+		}
+		if errors.Is(err, customerrors.ErrReceiverNotAccepting) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusUnprocessableEntity, customerrors.ErrReceiverNotAccepting)
+			return
+		}
+		var ruleErr customerrors.ErrRuleViolated
+		if errors.As(err, &ruleErr) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			RuleViolation(w, r, ruleErr)
+			return
+		}
+
+		var fundsErr customerrors.ErrInsufficientFunds
+		if errors.As(err, &fundsErr) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusUnprocessableEntity, fundsErr)
+			return
+		}
+
+		if errors.Is(err, customerrors.ErrSelfTransfer) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, customerrors.ErrSelfTransfer)
+			return
+		}
+
+		if errors.Is(err, customerrors.ErrWalletNotFound) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		Respond(w, r, http.StatusOK, resp)
+	}
+}
+
 func (h *Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
 	const fn = "handlers.GetBalance"
 
@@ -161,7 +513,7 @@ func (h *Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
 	)
 
 	userID := r.Context().Value(ctxKeyUserID).(string)
-	resp, err := h.svc.GetWalletBalance(r.Context(), userID)
+	resp, err := h.svc.GetWalletBalance(r.Context(), walletOwner(r))
 	if errors.Is(err, customerrors.ErrWalletNotFound) {
 		log.Warn(err.Error(), logger.String("X-UserID", userID))
 
@@ -178,6 +530,63 @@ func (h *Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
 	Respond(w, r, http.StatusOK, resp)
 }
 
+func (h *Handler) ListWallets(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.ListWallets"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	userID := r.Context().Value(ctxKeyUserID).(string)
+	resp, err := h.svc.ListWallets(r.Context(), walletOwner(r))
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) SetDefaultWallet(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.SetDefaultWallet"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	userID := r.Context().Value(ctxKeyUserID).(string)
+	owner := walletOwner(r)
+	err := h.svc.SetDefaultWallet(r.Context(), owner)
+	if errors.Is(err, customerrors.ErrWalletNotFound) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+		return
+	}
+	if errors.Is(err, customerrors.ErrWalletClosed) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusGone, customerrors.ErrWalletClosed)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	log.Info("default wallet changed", logger.String("X-UserID", userID), logger.String("wallet_id", owner.WalletID))
+
+	Respond(w, r, http.StatusOK, nil)
+}
+
 func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 	const fn = "handlers.GetStats"
 
@@ -188,13 +597,40 @@ func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 	)
 
 	userID := r.Context().Value(ctxKeyUserID).(string)
-	resp, err := h.svc.GetWalletStats(r.Context(), userID)
+	query := r.URL.Query()
+
+	// without parameters the stats of the current month are returned as before
+	var (
+		resp any
+		err  error
+	)
+	if !query.Has("from") && !query.Has("to") && !query.Has("group_by") {
+		resp, err = h.svc.GetWalletStats(r.Context(), walletOwner(r))
+	} else {
+		var req *models.WalletStatsReq
+		req, err = statsReq(query, h.cfg.BusinessLocation())
+		if err != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		req.Owner = walletOwner(r)
+
+		resp, err = h.svc.GetWalletStatsSeries(r.Context(), req)
+	}
 	if errors.Is(err, customerrors.ErrWalletNotFound) {
 		log.Warn(err.Error(), logger.String("X-UserID", userID))
 
 		Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
 		return
 	}
+	if errors.Is(err, customerrors.ErrInvalidStatsPeriod) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusBadRequest, customerrors.ErrInvalidStatsPeriod)
+		return
+	}
 	if err != nil {
 		log.Error(err.Error(), logger.String("X-UserID", userID))
 
@@ -204,3 +640,26 @@ func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 
 	Respond(w, r, http.StatusOK, resp)
 }
+
+// statsReq reads the range and buckets of the stats series from the query string
+func statsReq(query url.Values, location *time.Location) (*models.WalletStatsReq, error) {
+	req := &models.WalletStatsReq{
+		GroupBy: query.Get("group_by"),
+	}
+
+	var err error
+	if req.From, err = parseTimeParam(query, "from", location); err != nil {
+		return nil, err
+	}
+	if req.To, err = parseTimeParam(query, "to", location); err != nil {
+		return nil, err
+	}
+
+	switch req.GroupBy {
+	case "", models.StatsByDay, models.StatsByWeek, models.StatsByMonth:
+	default:
+		return nil, invalidParam("group_by")
+	}
+
+	return req, nil
+}
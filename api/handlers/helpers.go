@@ -2,7 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+	"github.com/parviz-yu/digital-wallet/pkg/security"
 )
 
 func Error(w http.ResponseWriter, r *http.Request, code int, err error) {
@@ -14,10 +20,107 @@ func Error(w http.ResponseWriter, r *http.Request, code int, err error) {
 
 }
 
+// RuleViolation responds with the limit rule the operation breaks
+func RuleViolation(w http.ResponseWriter, r *http.Request, err customerrors.ErrRuleViolated) {
+	resp := models.RuleViolationResp{
+		Error: err.Error(),
+		Rule:  err.Rule,
+	}
+	if err.Operations {
+		maxOperations := int(err.Limit)
+		resp.MaxOperations = &maxOperations
+	} else {
+		currency, lookupErr := money.LookupCurrency(err.Currency)
+		if lookupErr != nil {
+			currency = money.Currency{Code: err.Currency}
+		}
+		limit := currency.Format(money.Amount(err.Limit))
+		resp.Limit = &limit
+		resp.Currency = err.Currency
+	}
+
+	Respond(w, r, http.StatusUnprocessableEntity, resp)
+}
+
 func Respond(w http.ResponseWriter, r *http.Request, code int, data interface{}) {
+	var body []byte
+	if data != nil {
+		body, _ = json.Marshal(data)
+		body = append(body, '\n')
+	}
+
+	write(w, r, code, body)
+}
+
+// write sends the response body signed with the key the request was verified
+// with. Responses to unverified requests aren't signed
+func write(w http.ResponseWriter, r *http.Request, code int, body []byte) {
 	w.Header().Set("Content-Type", "application/json")
+	if signer, ok := r.Context().Value(ctxKeySigningKey).(*signingKey); ok {
+		payload := security.CanonicalResponse(r.Header.Get(nonceHeader), body)
+		w.Header().Set(digestHeader, security.Sign(signer.alg, signer.key.SecretKey, payload))
+		w.Header().Set(digestAlgHeader, string(signer.alg))
+		w.Header().Set(keyIDHeader, signer.key.KeyID)
+	}
+
 	w.WriteHeader(code)
-	if data != nil {
-		json.NewEncoder(w).Encode(data)
+	w.Write(body)
+}
+
+// walletStatusError returns the response to the error of a wallet status
+// forbidding the operation, so partners can tell such wallets from missing
+// ones. Other errors give nil
+func walletStatusError(err error) (int, error) {
+	for _, statusErr := range []error{
+		customerrors.ErrWalletFrozenCredit,
+		customerrors.ErrWalletFrozenDebit,
+		customerrors.ErrWalletBlocked,
+	} {
+		if errors.Is(err, statusErr) {
+			return http.StatusLocked, statusErr
+		}
 	}
+	if errors.Is(err, customerrors.ErrWalletClosed) {
+		return http.StatusGone, customerrors.ErrWalletClosed
+	}
+
+	return 0, nil
+}
+
+// amountError returns the response to an amount the wallet's currency can't
+// take: a malformed or too precise one, one below a unit of the currency or
+// one given in another currency. Other errors give nil
+func amountError(err error) (int, error) {
+	if errors.Is(err, money.ErrTooPrecise) || errors.Is(err, money.ErrInvalidAmount) {
+		return http.StatusBadRequest, err
+	}
+	if errors.Is(err, customerrors.ErrAmountTooSmall) {
+		return http.StatusBadRequest, customerrors.ErrAmountTooSmall
+	}
+	if errors.Is(err, customerrors.ErrCurrencyMismatch) {
+		return http.StatusUnprocessableEntity, customerrors.ErrCurrencyMismatch
+	}
+
+	return 0, nil
+}
+
+// validCurrency reports whether the currency of the request is empty or a
+// known one
+func validCurrency(code string) bool {
+	if code == "" {
+		return true
+	}
+	_, err := money.LookupCurrency(code)
+
+	return err == nil
+}
+
+// decodeError hides decoding details from the client, except amount
+// validation errors which the client can fix
+func decodeError(err error) error {
+	if errors.Is(err, money.ErrTooPrecise) || errors.Is(err, money.ErrInvalidAmount) {
+		return err
+	}
+
+	return ErrInvalidReqBody
 }
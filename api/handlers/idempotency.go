@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+	idempotencySaveTimeout  = 5 * time.Second
+)
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("invalid Idempotency-Key header value")
+)
+
+// Idempotency replays the stored response for requests retried with the same
+// Idempotency-Key header. Requests without the header are passed through.
+// Operations take the key and store their response in their own transaction,
+// the middleware stores only responses to requests rejected before that
+func (h *Handler) Idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.Idempotency"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID := r.Context().Value(ctxKeyUserID).(string)
+		if len(key) > maxIdempotencyKeyLength {
+			log.Warn(ErrInvalidIdempotencyKey.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidIdempotencyKey)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidReqBody)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		owner := walletOwner(r)
+		idemKey := &models.IdempotencyKey{
+			Key:         key,
+			PartnerID:   owner.PartnerID,
+			UserID:      owner.UserID,
+			RequestHash: requestHash(r, body),
+		}
+
+		stored, err := h.svc.GetIdempotentResponse(r.Context(), idemKey)
+		if errors.Is(err, customerrors.ErrIdempotencyKeyReused) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID), logger.String("key", key))
+
+			Error(w, r, http.StatusUnprocessableEntity, customerrors.ErrIdempotencyKeyReused)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if stored != nil {
+			log.Info("replaying stored response", logger.String("X-UserID", userID), logger.String("key", key))
+
+			replay(w, r, stored.StatusCode, stored.ResponseBody)
+			return
+		}
+
+		respBody := &bytes.Buffer{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(respBody)
+
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), ctxKeyIdempotency, idemKey)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		// successful operations have already stored their response, and
+		// server errors aren't final, the partner should be able to retry
+		if status < http.StatusBadRequest || status >= http.StatusInternalServerError {
+			return
+		}
+
+		// the request may be already cancelled by the client, but its result
+		// still has to be stored
+		ctx, cancel := context.WithTimeout(context.Background(), idempotencySaveTimeout)
+		defer cancel()
+
+		idemKey.StatusCode = status
+		idemKey.ResponseBody = respBody.Bytes()
+		if err := h.svc.SaveIdempotentResponse(ctx, idemKey); err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID), logger.String("key", key))
+		}
+	})
+}
+
+// idempotencyKey returns the key the request is made with, nil if the request
+// has no Idempotency-Key header
+func idempotencyKey(r *http.Request) *models.IdempotencyKey {
+	key, _ := r.Context().Value(ctxKeyIdempotency).(*models.IdempotencyKey)
+	return key
+}
+
+// replay writes the stored response of the request made with the same key.
+// The stored body is signed again for the nonce of this request
+func replay(w http.ResponseWriter, r *http.Request, code int, body []byte) {
+	w.Header().Set(idempotentReplayHeader, "true")
+	write(w, r, code, body)
+}
+
+// replayCompleted replays the response of the request which completed with
+// the same key while this one was on its way. It reports whether err was such
+func replayCompleted(w http.ResponseWriter, r *http.Request, err error) bool {
+	var completed customerrors.ErrRequestCompleted
+	if !errors.As(err, &completed) {
+		return false
+	}
+
+	replay(w, r, completed.StatusCode, completed.Body)
+	return true
+}
+
+// idempotencyError returns the response to the key of the request being
+// taken by another request in the meantime. Other errors give nil
+func idempotencyError(err error) (int, error) {
+	if errors.Is(err, customerrors.ErrIdempotencyKeyReused) {
+		return http.StatusUnprocessableEntity, customerrors.ErrIdempotencyKeyReused
+	}
+	if errors.Is(err, customerrors.ErrRequestInProgress) {
+		return http.StatusConflict, customerrors.ErrRequestInProgress
+	}
+
+	return 0, nil
+}
+
+// requestHash identifies the request the key was used with
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
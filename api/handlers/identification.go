@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+)
+
+var (
+	ErrInvalidIdentification = errors.New("full_name, document_type, document_number and birth_date are required")
+	ErrInvalidBirthDate      = errors.New("birth_date must be in YYYY-MM-DD format")
+	ErrIdentificationTooLong = errors.New("full_name must be at most 255 characters, document_type and document_number at most 50")
+	ErrInvalidID             = errors.New("invalid id")
+	ErrInvalidStatus         = errors.New("invalid status")
+)
+
+func (h *Handler) SubmitIdentification() http.HandlerFunc {
+	type request struct {
+		FullName       string `json:"full_name"`
+		DocumentType   string `json:"document_type"`
+		DocumentNumber string `json:"document_number"`
+		BirthDate      string `json:"birth_date"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.SubmitIdentification"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userID := r.Context().Value(ctxKeyUserID).(string)
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidReqBody)
+			return
+		}
+		defer r.Body.Close()
+
+		if req.FullName == "" || req.DocumentType == "" || req.DocumentNumber == "" || req.BirthDate == "" {
+			log.Warn(ErrInvalidIdentification.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidIdentification)
+			return
+		}
+		// the columns' sizes, counted in characters like VARCHAR does
+		if utf8.RuneCountInString(req.FullName) > 255 || utf8.RuneCountInString(req.DocumentType) > 50 ||
+			utf8.RuneCountInString(req.DocumentNumber) > 50 {
+			log.Warn(ErrIdentificationTooLong.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrIdentificationTooLong)
+			return
+		}
+
+		birthDate, err := time.Parse(models.DateLayout, req.BirthDate)
+		if err != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidBirthDate)
+			return
+		}
+
+		identificationReq := models.IdentificationReq{
+			Owner:          walletOwner(r),
+			FullName:       req.FullName,
+			DocumentType:   req.DocumentType,
+			DocumentNumber: req.DocumentNumber,
+			BirthDate:      birthDate,
+		}
+
+		resp, err := h.svc.SubmitIdentification(r.Context(), &identificationReq)
+		if code, statusErr := walletStatusError(err); statusErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, statusErr)
+			return
+		}
+		if errors.Is(err, customerrors.ErrWalletNotFound) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrAlreadyIdentified) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrAlreadyIdentified)
+			return
+		}
+		if errors.Is(err, customerrors.ErrIdentificationPending) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrIdentificationPending)
+			return
+		}
+		if errors.Is(err, customerrors.ErrCurrencyNotSupported) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusUnprocessableEntity, customerrors.ErrCurrencyNotSupported)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		Respond(w, r, http.StatusAccepted, resp)
+	}
+}
+
+func (h *Handler) GetIdentification(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.GetIdentification"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	userID := r.Context().Value(ctxKeyUserID).(string)
+	resp, err := h.svc.GetIdentification(r.Context(), walletOwner(r))
+	if errors.Is(err, customerrors.ErrWalletNotFound) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+		return
+	}
+	if errors.Is(err, customerrors.ErrIdentificationNotFound) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrIdentificationNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) ListIdentifications(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.ListIdentifications"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.IdentificationPending
+	case models.IdentificationPending, models.IdentificationApproved, models.IdentificationRejected:
+	default:
+		Error(w, r, http.StatusBadRequest, ErrInvalidStatus)
+		return
+	}
+
+	resp, err := h.svc.ListIdentifications(r.Context(), status)
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) ApproveIdentification(w http.ResponseWriter, r *http.Request) {
+	h.reviewIdentification(w, r, &models.IdentificationReview{Approve: true})
+}
+
+func (h *Handler) RejectIdentification() http.HandlerFunc {
+	type request struct {
+		Reason string `json:"reason"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil || req.Reason == "" {
+			Error(w, r, http.StatusBadRequest, ErrInvalidReqBody)
+			return
+		}
+		defer r.Body.Close()
+
+		h.reviewIdentification(w, r, &models.IdentificationReview{Reason: req.Reason})
+	}
+}
+
+func (h *Handler) reviewIdentification(w http.ResponseWriter, r *http.Request, review *models.IdentificationReview) {
+	const fn = "handlers.reviewIdentification"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		Error(w, r, http.StatusBadRequest, ErrInvalidID)
+		return
+	}
+
+	review.ID = id
+	review.OperatorID = operatorID
+
+	resp, err := h.svc.ReviewIdentification(r.Context(), review)
+	if errors.Is(err, customerrors.ErrIdentificationNotFound) {
+		log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("id", id))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrIdentificationNotFound)
+		return
+	}
+	if errors.Is(err, customerrors.ErrIdentificationNotPending) {
+		log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("id", id))
+
+		Error(w, r, http.StatusConflict, customerrors.ErrIdentificationNotPending)
+		return
+	}
+	if code, statusErr := walletStatusError(err); statusErr != nil {
+		log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("id", id))
+
+		Error(w, r, code, statusErr)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("id", id))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	log.Info("identification reviewed",
+		logger.String("X-OperatorId", operatorID),
+		logger.Int("id", id),
+		logger.String("status", resp.Status),
+	)
+
+	Respond(w, r, http.StatusOK, resp)
+}
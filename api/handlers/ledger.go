@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+)
+
+// CheckLedger reports whether the ledger invariants hold. Broken invariants
+// are logged, the response is 200 either way
+func (h *Handler) CheckLedger(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.CheckLedger"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+	resp, err := h.svc.CheckLedger(r.Context())
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !resp.Balanced {
+		log.Warn("ledger invariants broken",
+			logger.String("X-OperatorId", operatorID),
+			logger.Any("totals", resp.Totals),
+			logger.Any("unbalanced_entries", resp.UnbalancedEntries),
+			logger.Any("account_mismatches", resp.AccountMismatches),
+			logger.Any("wallet_mismatches", resp.WalletMismatches),
+		)
+	}
+
+	Respond(w, r, http.StatusOK, resp)
+}
@@ -1,32 +1,130 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"errors"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
 	"github.com/parviz-yu/digital-wallet/pkg/logger"
+	"github.com/parviz-yu/digital-wallet/pkg/security"
 )
 
 type ctxKey int8
 
 const (
 	ctxKeyUserID ctxKey = iota
+	ctxKeyPartner
+	ctxKeyOperatorID
+	ctxKeySigningKey
+	ctxKeyIdempotency
 )
 
 const (
-	userIDHeader = "X-UserId"
-	digestHeader = "X-Digest"
+	partnerIDHeader  = "X-PartnerId"
+	keyIDHeader      = "X-KeyId"
+	timestampHeader  = "X-Timestamp"
+	nonceHeader      = "X-Nonce"
+	userIDHeader     = "X-UserId"
+	digestHeader     = "X-Digest"
+	digestAlgHeader  = "X-Digest-Alg"
+	adminTokenHeader = "X-Admin-Token"
+	operatorIDHeader = "X-OperatorId"
 )
 
+// userIDLength is the length of the UUID users are identified by
+const userIDLength = 36
+
+const maxNonceLength = 64
+
+// signingKey is the partner key and algorithm the request was verified with,
+// the response is signed with the same ones
+type signingKey struct {
+	key *models.PartnerKey
+	alg security.Algorithm
+}
+
 var (
+	ErrNoPartnerIDHeader    = errors.New("X-PartnerId header required")
+	ErrInvalidPartnerID     = errors.New("invalid X-PartnerId header value")
+	ErrInvalidKeyID         = errors.New("invalid X-KeyId header value")
 	ErrNoUserIDHeader       = errors.New("X-UserId header required")
 	ErrNoXDigestHeader      = errors.New("X-Digest header required")
 	ErrInvalidXDigestHeader = errors.New("invalid X-Digest header value")
+	ErrInvalidDigestAlg     = errors.New("invalid X-Digest-Alg header value")
+	ErrSHA1Disabled         = errors.New("hmac-sha1 signatures are no longer accepted")
+	ErrDigestAlgMismatch    = errors.New("X-Digest-Alg doesn't match the partner's signature algorithm")
+	ErrNoReplayHeaders      = errors.New("X-Timestamp and X-Nonce headers required")
+	ErrInvalidTimestamp     = errors.New("invalid X-Timestamp header value")
+	ErrInvalidNonce         = errors.New("invalid X-Nonce header value")
+	ErrInternal             = errors.New("internal server error")
+	ErrBodyTooLarge         = errors.New("request body too large")
+	ErrInvalidAdminToken    = errors.New("invalid X-Admin-Token header value")
+	ErrNoOperatorIDHeader   = errors.New("X-OperatorId header required")
 )
 
+// AuthMiddlewarePartner loads the partner making the request, whose keys
+// are used to verify X-Digest and whose wallets the request can access.
+// X-KeyId narrows the keys down to the one the request is signed with
+func (h *Handler) AuthMiddlewarePartner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.AuthMiddlewarePartner"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		header := r.Header.Get(partnerIDHeader)
+		if header == "" {
+			Error(w, r, http.StatusUnauthorized, ErrNoPartnerIDHeader)
+			return
+		}
+
+		partnerID, err := strconv.Atoi(header)
+		if err != nil {
+			Error(w, r, http.StatusUnauthorized, ErrInvalidPartnerID)
+			return
+		}
+
+		partner, err := h.svc.GetPartner(r.Context(), partnerID)
+		if errors.Is(err, customerrors.ErrPartnerNotFound) {
+			log.Warn(err.Error(), logger.Int("X-PartnerId", partnerID))
+
+			Error(w, r, http.StatusUnauthorized, ErrInvalidPartnerID)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.Int("X-PartnerId", partnerID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		if keyID := r.Header.Get(keyIDHeader); keyID != "" {
+			partner.Keys = filterKeys(partner.Keys, keyID)
+		}
+		if len(partner.Keys) == 0 {
+			log.Warn("no active keys", logger.Int("X-PartnerId", partnerID), logger.String("X-KeyId", r.Header.Get(keyIDHeader)))
+
+			Error(w, r, http.StatusUnauthorized, ErrInvalidKeyID)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxKeyPartner, partner)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func AuthMiddlewareUserID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Header.Get(userIDHeader)
@@ -40,6 +138,198 @@ func AuthMiddlewareUserID(next http.Handler) http.Handler {
 	})
 }
 
+// AuthMiddlewareAdmin lets through operators who know the admin token.
+// X-OperatorId names the operator in the audit log
+func (h *Handler) AuthMiddlewareAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(adminTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.AdminToken)) != 1 {
+			h.log.Warn(ErrInvalidAdminToken.Error(), logger.String("path", r.URL.Path))
+
+			Error(w, r, http.StatusUnauthorized, ErrInvalidAdminToken)
+			return
+		}
+
+		operatorID := r.Header.Get(operatorIDHeader)
+		if operatorID == "" {
+			Error(w, r, http.StatusUnauthorized, ErrNoOperatorIDHeader)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxKeyOperatorID, operatorID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// walletOwner returns the wallet the request is made for: the one in the
+// path, or the user's default wallet on routes without it
+func walletOwner(r *http.Request) models.WalletOwner {
+	return models.WalletOwner{
+		PartnerID: r.Context().Value(ctxKeyPartner).(*models.Partner).ID,
+		UserID:    r.Context().Value(ctxKeyUserID).(string),
+		WalletID:  chi.URLParam(r, "walletID"),
+	}
+}
+
+// VerifySignature checks X-Digest against the exact bytes of the request body
+// before any handler decodes it. The body is buffered up to the configured
+// size and handed to the next handler unchanged
+func (h *Handler) VerifySignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.VerifySignature"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userID := r.Context().Value(ctxKeyUserID).(string)
+		digest := r.Header.Get(digestHeader)
+		if digest == "" {
+			log.Warn(ErrNoXDigestHeader.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusUnauthorized, ErrNoXDigestHeader)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.cfg.MaxBodySize))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidReqBody)
+			return
+		}
+		r.Body.Close()
+
+		signer, code, err := h.verifyDigest(r, body, digest)
+		if err != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, err)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		ctx := context.WithValue(r.Context(), ctxKeySigningKey, signer)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// signedPath returns the request path with its query string as it was sent,
+// so parameters of bodyless requests can't be changed without the signature
+func signedPath(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.URL.Path
+	}
+
+	return r.URL.Path + "?" + r.URL.RawQuery
+}
+
+// verifyDigest checks the request signature against the keys of the partner
+// making the request and logs the version of the key which verified it.
+// The signature covers method, path with query, X-UserId, X-Timestamp,
+// X-Nonce and the body, and every nonce is accepted only once. It returns
+// the key to sign the response with or the status code and error to respond
+// with
+func (h *Handler) verifyDigest(r *http.Request, body []byte, digest string) (*signingKey, int, error) {
+	const fn = "handlers.verifyDigest"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	partner := r.Context().Value(ctxKeyPartner).(*models.Partner)
+	timestamp, nonce := r.Header.Get(timestampHeader), r.Header.Get(nonceHeader)
+	if timestamp == "" || nonce == "" {
+		return nil, http.StatusUnauthorized, ErrNoReplayHeaders
+	}
+	if len(nonce) > maxNonceLength {
+		return nil, http.StatusUnauthorized, ErrInvalidNonce
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, http.StatusUnauthorized, ErrInvalidTimestamp
+	}
+
+	// the partner's algorithm always wins, otherwise a caller could
+	// downgrade the signature to a weaker one
+	alg, err := security.ParseAlgorithm(partner.SignatureAlg)
+	if err != nil {
+		log.Error(err.Error(), logger.Int("X-PartnerId", partner.ID), logger.String("alg", partner.SignatureAlg))
+
+		return nil, http.StatusInternalServerError, ErrInternal
+	}
+	if header := r.Header.Get(digestAlgHeader); header != "" {
+		headerAlg, err := security.ParseAlgorithm(header)
+		if err != nil {
+			return nil, http.StatusUnauthorized, ErrInvalidDigestAlg
+		}
+		if headerAlg != alg {
+			return nil, http.StatusUnauthorized, ErrDigestAlgMismatch
+		}
+	}
+	if alg == security.HMACSHA1 && !h.cfg.SignatureAllowSHA1 {
+		return nil, http.StatusUnauthorized, ErrSHA1Disabled
+	}
+
+	payload := security.CanonicalRequest(r.Method, signedPath(r), r.Header.Get(userIDHeader), timestamp, nonce, body)
+
+	var verifiedBy *models.PartnerKey
+	for i, key := range partner.Keys {
+		if security.Verify(alg, key.SecretKey, payload, digest) {
+			verifiedBy = &partner.Keys[i]
+			break
+		}
+	}
+	if verifiedBy == nil {
+		return nil, http.StatusUnauthorized, ErrInvalidXDigestHeader
+	}
+
+	// the nonce is used only after the signature is verified, so nobody
+	// else can burn partner's nonces
+	err = h.svc.CheckReplay(r.Context(), partner.ID, nonce, time.Unix(signedAt, 0))
+	if errors.Is(err, customerrors.ErrStaleRequest) {
+		return nil, http.StatusUnauthorized, customerrors.ErrStaleRequest
+	}
+	if errors.Is(err, customerrors.ErrNonceReused) {
+		return nil, http.StatusUnauthorized, customerrors.ErrNonceReused
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.Int("X-PartnerId", partner.ID))
+
+		return nil, http.StatusInternalServerError, ErrInternal
+	}
+
+	log.Info("signature verified",
+		logger.Int("X-PartnerId", partner.ID),
+		logger.String("key_id", verifiedBy.KeyID),
+		logger.String("alg", string(alg)),
+	)
+
+	return &signingKey{key: verifiedBy, alg: alg}, 0, nil
+}
+
+func filterKeys(keys []models.PartnerKey, keyID string) []models.PartnerKey {
+	for _, key := range keys {
+		if key.KeyID == keyID {
+			return []models.PartnerKey{key}
+		}
+	}
+
+	return nil
+}
+
 func NewMWLogger(log logger.LoggerI) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		log := logger.With(
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+)
+
+var (
+	ErrInvalidKeyValidity = errors.New("not_after must be later than not_before")
+	ErrNoKeyID            = errors.New("key_id required")
+)
+
+func (h *Handler) ListPartnerKeys(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.ListPartnerKeys"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+	partnerID, err := strconv.Atoi(chi.URLParam(r, "partnerID"))
+	if err != nil {
+		Error(w, r, http.StatusBadRequest, ErrInvalidID)
+		return
+	}
+
+	resp, err := h.svc.ListPartnerKeys(r.Context(), partnerID)
+	if errors.Is(err, customerrors.ErrPartnerNotFound) {
+		log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("partner_id", partnerID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrPartnerNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("partner_id", partnerID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) CreatePartnerKey() http.HandlerFunc {
+	type request struct {
+		KeyID     string     `json:"key_id"`
+		NotBefore *time.Time `json:"not_before"`
+		NotAfter  *time.Time `json:"not_after"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.CreatePartnerKey"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+		partnerID, err := strconv.Atoi(chi.URLParam(r, "partnerID"))
+		if err != nil {
+			Error(w, r, http.StatusBadRequest, ErrInvalidID)
+			return
+		}
+
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidReqBody)
+			return
+		}
+		defer r.Body.Close()
+
+		if req.KeyID == "" {
+			Error(w, r, http.StatusBadRequest, ErrNoKeyID)
+			return
+		}
+
+		keyReq := models.PartnerKeyReq{
+			PartnerID:  partnerID,
+			KeyID:      req.KeyID,
+			NotBefore:  time.Now(),
+			NotAfter:   req.NotAfter,
+			OperatorID: operatorID,
+		}
+		if req.NotBefore != nil {
+			keyReq.NotBefore = *req.NotBefore
+		}
+		if keyReq.NotAfter != nil && !keyReq.NotAfter.After(keyReq.NotBefore) {
+			Error(w, r, http.StatusBadRequest, ErrInvalidKeyValidity)
+			return
+		}
+
+		resp, err := h.svc.CreatePartnerKey(r.Context(), &keyReq)
+		if errors.Is(err, customerrors.ErrPartnerNotFound) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("partner_id", partnerID))
+
+			Error(w, r, http.StatusNotFound, customerrors.ErrPartnerNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrPartnerKeyExists) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("partner_id", partnerID))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrPartnerKeyExists)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("partner_id", partnerID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		log.Info("partner key created",
+			logger.String("X-OperatorId", operatorID),
+			logger.Int("partner_id", partnerID),
+			logger.String("key_id", req.KeyID),
+		)
+
+		Respond(w, r, http.StatusCreated, resp)
+	}
+}
+
+func (h *Handler) RevokePartnerKey(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.RevokePartnerKey"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+	partnerID, err := strconv.Atoi(chi.URLParam(r, "partnerID"))
+	if err != nil {
+		Error(w, r, http.StatusBadRequest, ErrInvalidID)
+		return
+	}
+	keyID := chi.URLParam(r, "keyID")
+
+	err = h.svc.RevokePartnerKey(r.Context(), partnerID, keyID, operatorID)
+	if errors.Is(err, customerrors.ErrPartnerKeyNotFound) {
+		log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("partner_id", partnerID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrPartnerKeyNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("partner_id", partnerID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	log.Info("partner key revoked",
+		logger.String("X-OperatorId", operatorID),
+		logger.Int("partner_id", partnerID),
+		logger.String("key_id", keyID),
+	)
+
+	Respond(w, r, http.StatusOK, nil)
+}
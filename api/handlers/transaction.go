@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// history page sizes
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var (
+	ErrInvalidQueryParam = errors.New("invalid query parameter")
+	ErrNoTransactionID   = errors.New("transaction_id required")
+)
+
+func (h *Handler) ListTransactions(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.ListTransactions"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	userID := r.Context().Value(ctxKeyUserID).(string)
+	req, err := transactionsReq(r.URL.Query(), h.cfg.BusinessLocation())
+	if err != nil {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusBadRequest, err)
+		return
+	}
+	req.Owner = walletOwner(r)
+
+	resp, err := h.svc.ListTransactions(r.Context(), req)
+	if errors.Is(err, customerrors.ErrWalletNotFound) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+		return
+	}
+	if errors.Is(err, customerrors.ErrInvalidCursor) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusBadRequest, customerrors.ErrInvalidCursor)
+		return
+	}
+	if errors.Is(err, customerrors.ErrInvalidAmountRange) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusBadRequest, customerrors.ErrInvalidAmountRange)
+		return
+	}
+	// amount bounds finer than the wallet's currency
+	if errors.Is(err, money.ErrTooPrecise) {
+		log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-UserID", userID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) Reverse() http.HandlerFunc {
+	type request struct {
+		TransactionID int            `json:"transaction_id"`
+		Amount        *money.Decimal `json:"amount"` // the rest of the top-up if omitted
+		Currency      string         `json:"currency"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.Reverse"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		userID := r.Context().Value(ctxKeyUserID).(string)
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusBadRequest, decodeError(err))
+			return
+		}
+		defer r.Body.Close()
+
+		if req.TransactionID <= 0 {
+			Error(w, r, http.StatusBadRequest, ErrNoTransactionID)
+			return
+		}
+
+		reversalReq := models.ReversalReq{
+			Owner:          walletOwner(r),
+			TransactionID:  req.TransactionID,
+			IdempotencyKey: idempotencyKey(r),
+		}
+		if req.Amount != nil {
+			if !req.Amount.Positive() {
+				Error(w, r, http.StatusBadRequest, ErrInvalidAmount)
+				return
+			}
+			reversalReq.Amount = *req.Amount
+		}
+		if !validCurrency(req.Currency) {
+			Error(w, r, http.StatusBadRequest, ErrInvalidCurrency)
+			return
+		}
+		reversalReq.Currency = req.Currency
+
+		resp, err := h.svc.Reverse(r.Context(), &reversalReq)
+		if code, statusErr := walletStatusError(err); statusErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, statusErr)
+			return
+		}
+		if replayCompleted(w, r, err) {
+			log.Info("replaying stored response", logger.String("X-UserID", userID))
+			return
+		}
+		if code, idemErr := idempotencyError(err); idemErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, idemErr)
+			return
+		}
+		if code, amountErr := amountError(err); amountErr != nil {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, code, amountErr)
+			return
+		}
+		var insufficientErr customerrors.ErrInsufficientFunds
+		if errors.As(err, &insufficientErr) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusUnprocessableEntity, insufficientErr)
+			return
+		}
+		if errors.Is(err, customerrors.ErrWalletNotFound) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrTransactionNotFound) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusNotFound, customerrors.ErrTransactionNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrNotReversible) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusUnprocessableEntity, customerrors.ErrNotReversible)
+			return
+		}
+		if errors.Is(err, customerrors.ErrReversalTooLarge) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusUnprocessableEntity, customerrors.ErrReversalTooLarge)
+			return
+		}
+		if errors.Is(err, customerrors.ErrAlreadyReversed) {
+			log.Warn(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrAlreadyReversed)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-UserID", userID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		Respond(w, r, http.StatusOK, resp)
+	}
+}
+
+// transactionsReq reads history filters from the query string
+func transactionsReq(query url.Values, location *time.Location) (*models.TransactionsReq, error) {
+	req := &models.TransactionsReq{
+		Type:   query.Get("type"),
+		Cursor: query.Get("cursor"),
+		Limit:  defaultPageSize,
+	}
+
+	var err error
+	if req.From, err = parseTimeParam(query, "from", location); err != nil {
+		return nil, err
+	}
+	if req.To, err = parseTimeParam(query, "to", location); err != nil {
+		return nil, err
+	}
+	if !req.From.IsZero() && !req.To.IsZero() && !req.From.Before(req.To) {
+		return nil, invalidParam("to")
+	}
+
+	switch req.Type {
+	case "", models.TxTypeTopUp, models.TxTypeWithdrawal, models.TxTypeTransferIn, models.TxTypeTransferOut, models.TxTypeReversal:
+	default:
+		return nil, invalidParam("type")
+	}
+
+	if req.MinAmount, err = parseAmountParam(query, "min_amount"); err != nil {
+		return nil, err
+	}
+	if req.MaxAmount, err = parseAmountParam(query, "max_amount"); err != nil {
+		return nil, err
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		req.Limit, err = strconv.Atoi(limit)
+		if err != nil || req.Limit < 1 || req.Limit > maxPageSize {
+			return nil, invalidParam("limit")
+		}
+	}
+
+	return req, nil
+}
+
+// parseTimeParam accepts a RFC 3339 timestamp or a date, which starts at
+// midnight of the location. Missing parameter gives the zero time
+func parseTimeParam(query url.Values, name string, location *time.Location) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(models.DateLayout, value, location)
+	if err != nil {
+		return time.Time{}, invalidParam(name)
+	}
+
+	return t, nil
+}
+
+// parseAmountParam reads an amount in major units of the wallet's currency,
+// which the service converts. Missing parameter gives an empty amount
+func parseAmountParam(query url.Values, name string) (money.Decimal, error) {
+	value := query.Get(name)
+	if value == "" {
+		return "", nil
+	}
+
+	amount, err := money.ParseDecimal(value)
+	if err != nil || !amount.Positive() {
+		return "", invalidParam(name)
+	}
+
+	return amount, nil
+}
+
+func invalidParam(name string) error {
+	return fmt.Errorf("%w %s", ErrInvalidQueryParam, name)
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+)
+
+var ErrInvalidRule = errors.New("rule must be one of min_amount, max_amount, daily_top_up, monthly_top_up, daily_operations, max_balance")
+
+func (h *Handler) GetWalletLimits(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.GetWalletLimits"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+	walletID := chi.URLParam(r, "walletID")
+
+	resp, err := h.svc.GetWalletLimits(r.Context(), walletID)
+	if errors.Is(err, customerrors.ErrWalletNotFound) {
+		log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) UpdateWalletLimits() http.HandlerFunc {
+	type request struct {
+		Currency      string                  `json:"currency"` // of the limits and lifted rules, TJS if empty
+		Limits        models.WalletTypeLimits `json:"limits"`
+		Lift          []string                `json:"lift"`
+		EffectiveFrom *time.Time              `json:"effective_from"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.UpdateWalletLimits"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+		walletID := chi.URLParam(r, "walletID")
+
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+			Error(w, r, http.StatusBadRequest, decodeError(err))
+			return
+		}
+		defer r.Body.Close()
+
+		if req.Limits == (models.WalletTypeLimits{}) && len(req.Lift) == 0 {
+			Error(w, r, http.StatusBadRequest, ErrNothingToUpdate)
+			return
+		}
+		currency, err := limitsCurrency(req.Currency)
+		if err != nil {
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := validateLimits(req.Limits, req.Lift, currency); err != nil {
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		limitsReq := models.WalletLimitsReq{
+			WalletID:      walletID,
+			Currency:      currency.Code,
+			Limits:        req.Limits,
+			Lift:          req.Lift,
+			EffectiveFrom: time.Now(),
+			OperatorID:    operatorID,
+		}
+		if req.EffectiveFrom != nil {
+			if req.EffectiveFrom.Before(limitsReq.EffectiveFrom) {
+				Error(w, r, http.StatusBadRequest, ErrEffectiveFromInPast)
+				return
+			}
+			limitsReq.EffectiveFrom = *req.EffectiveFrom
+		}
+
+		resp, err := h.svc.UpdateWalletLimits(r.Context(), &limitsReq)
+		if errors.Is(err, customerrors.ErrWalletNotFound) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrCurrencyMismatch) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusUnprocessableEntity, customerrors.ErrCurrencyMismatch)
+			return
+		}
+		if errors.Is(err, customerrors.ErrWalletClosed) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrWalletClosed)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		log.Info("wallet limits updated",
+			logger.String("X-OperatorId", operatorID),
+			logger.String("wallet_id", walletID),
+			logger.Any("effective_from", limitsReq.EffectiveFrom),
+		)
+
+		Respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (h *Handler) DeleteWalletLimit(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.DeleteWalletLimit"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+	walletID := chi.URLParam(r, "walletID")
+	rule := chi.URLParam(r, "rule")
+
+	switch rule {
+	case models.RuleMinAmount, models.RuleMaxAmount, models.RuleDailyTopUp,
+		models.RuleMonthlyTopUp, models.RuleDailyOperations, models.RuleMaxBalance:
+	default:
+		Error(w, r, http.StatusBadRequest, ErrInvalidRule)
+		return
+	}
+
+	err := h.svc.DeleteWalletLimit(r.Context(), walletID, rule, operatorID)
+	if errors.Is(err, customerrors.ErrWalletNotFound) {
+		log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+		return
+	}
+	if errors.Is(err, customerrors.ErrWalletLimitNotFound) {
+		log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+		Error(w, r, http.StatusNotFound, customerrors.ErrWalletLimitNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	log.Info("wallet limit deleted",
+		logger.String("X-OperatorId", operatorID),
+		logger.String("wallet_id", walletID),
+		logger.String("rule", rule),
+	)
+
+	Respond(w, r, http.StatusOK, nil)
+}
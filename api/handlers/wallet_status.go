@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+)
+
+var (
+	ErrInvalidWalletStatus      = errors.New("status must be one of active, frozen_credit, frozen_debit, blocked, closed")
+	ErrInvalidStatusReason      = errors.New("unknown reason code")
+	ErrPayoutDestinationTooLong = errors.New("payout_destination must be at most 255 characters")
+)
+
+// statusReasons are reason codes an operator can give for a status change
+var statusReasons = map[string]bool{
+	models.ReasonCustomerRequest:  true,
+	models.ReasonFraudSuspected:   true,
+	models.ReasonCompromised:      true,
+	models.ReasonDeceased:         true,
+	models.ReasonCourtOrder:       true,
+	models.ReasonComplianceReview: true,
+	models.ReasonReviewCompleted:  true,
+	models.ReasonOther:            true,
+}
+
+func (h *Handler) ChangeWalletStatus() http.HandlerFunc {
+	type request struct {
+		Status            string `json:"status"`
+		Reason            string `json:"reason"`
+		Comment           string `json:"comment"`
+		PayoutDestination string `json:"payout_destination"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.ChangeWalletStatus"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+		walletID := chi.URLParam(r, "walletID")
+
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+			Error(w, r, http.StatusBadRequest, ErrInvalidReqBody)
+			return
+		}
+		defer r.Body.Close()
+
+		switch req.Status {
+		case models.WalletActive, models.WalletFrozenCredit, models.WalletFrozenDebit,
+			models.WalletBlocked, models.WalletClosed:
+		default:
+			Error(w, r, http.StatusBadRequest, ErrInvalidWalletStatus)
+			return
+		}
+		if !statusReasons[req.Reason] {
+			Error(w, r, http.StatusBadRequest, ErrInvalidStatusReason)
+			return
+		}
+		// the column's size, counted in characters like VARCHAR does
+		if utf8.RuneCountInString(req.PayoutDestination) > 255 {
+			log.Warn(ErrPayoutDestinationTooLong.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusBadRequest, ErrPayoutDestinationTooLong)
+			return
+		}
+
+		resp, err := h.svc.ChangeWalletStatus(r.Context(), &models.WalletStatusReq{
+			WalletID:          walletID,
+			Status:            req.Status,
+			Reason:            req.Reason,
+			Comment:           req.Comment,
+			PayoutDestination: req.PayoutDestination,
+			OperatorID:        operatorID,
+		})
+		if errors.Is(err, customerrors.ErrWalletNotFound) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusNotFound, customerrors.ErrWalletNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrWalletClosed) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrWalletClosed)
+			return
+		}
+		if errors.Is(err, customerrors.ErrWalletStatusUnchanged) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrWalletStatusUnchanged)
+			return
+		}
+		if errors.Is(err, customerrors.ErrBalanceNotPaidOut) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusUnprocessableEntity, customerrors.ErrBalanceNotPaidOut)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.String("wallet_id", walletID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		log.Info("wallet status changed",
+			logger.String("X-OperatorId", operatorID),
+			logger.String("wallet_id", walletID),
+			logger.String("status", resp.Status),
+			logger.String("reason", resp.Reason),
+		)
+
+		Respond(w, r, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+var (
+	ErrNoWalletTypeName      = errors.New("name required")
+	ErrWalletTypeNameTooLong = errors.New("name must be at most 100 characters")
+	ErrNoMaxBalance          = errors.New("max_balance required")
+	ErrNegativeLimit         = errors.New("limits must not be negative")
+	ErrMinAboveMax           = errors.New("min_amount must not exceed max_amount")
+	ErrInvalidLift           = errors.New("lift must name rules other than max_balance which aren't set in limits")
+	ErrEffectiveFromInPast   = errors.New("effective_from must not be in the past")
+	ErrNothingToUpdate       = errors.New("nothing to update")
+)
+
+func (h *Handler) ListWalletTypes(w http.ResponseWriter, r *http.Request) {
+	const fn = "handlers.ListWalletTypes"
+
+	log := logger.With(
+		h.log,
+		logger.String("fn", fn),
+		logger.String("request_id", middleware.GetReqID(r.Context())),
+	)
+
+	operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+	resp, err := h.svc.ListWalletTypes(r.Context())
+	if err != nil {
+		log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+		Error(w, r, http.StatusInternalServerError, nil)
+		return
+	}
+
+	Respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) CreateWalletType() http.HandlerFunc {
+	type request struct {
+		Name     string                  `json:"name"`
+		Currency string                  `json:"currency"` // of the limits, TJS if empty
+		Limits   models.WalletTypeLimits `json:"limits"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.CreateWalletType"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+			Error(w, r, http.StatusBadRequest, decodeError(err))
+			return
+		}
+		defer r.Body.Close()
+
+		if req.Name == "" {
+			Error(w, r, http.StatusBadRequest, ErrNoWalletTypeName)
+			return
+		}
+		// the column's size, counted in characters like VARCHAR does
+		if utf8.RuneCountInString(req.Name) > 100 {
+			log.Warn(ErrWalletTypeNameTooLong.Error(), logger.String("X-OperatorId", operatorID))
+
+			Error(w, r, http.StatusBadRequest, ErrWalletTypeNameTooLong)
+			return
+		}
+		if req.Limits.MaxBalance == nil {
+			Error(w, r, http.StatusBadRequest, ErrNoMaxBalance)
+			return
+		}
+		currency, err := limitsCurrency(req.Currency)
+		if err != nil {
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := validateLimits(req.Limits, nil, currency); err != nil {
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		resp, err := h.svc.CreateWalletType(r.Context(), &models.WalletTypeReq{
+			Name:          req.Name,
+			Currency:      currency.Code,
+			Limits:        req.Limits,
+			EffectiveFrom: time.Now(),
+			OperatorID:    operatorID,
+		})
+		if errors.Is(err, customerrors.ErrWalletTypeExists) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrWalletTypeExists)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		log.Info("wallet type created",
+			logger.String("X-OperatorId", operatorID),
+			logger.Int("wallet_type", resp.ID),
+		)
+
+		Respond(w, r, http.StatusCreated, resp)
+	}
+}
+
+func (h *Handler) UpdateWalletType() http.HandlerFunc {
+	type request struct {
+		Name          string                  `json:"name"`
+		Currency      string                  `json:"currency"` // of the limits and lifted rules, TJS if empty
+		Limits        models.WalletTypeLimits `json:"limits"`
+		Lift          []string                `json:"lift"`
+		EffectiveFrom *time.Time              `json:"effective_from"`
+		Force         bool                    `json:"force"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const fn = "handlers.UpdateWalletType"
+
+		log := logger.With(
+			h.log,
+			logger.String("fn", fn),
+			logger.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		operatorID := r.Context().Value(ctxKeyOperatorID).(string)
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			Error(w, r, http.StatusBadRequest, ErrInvalidID)
+			return
+		}
+
+		req := request{}
+		jsonDecoder := json.NewDecoder(r.Body)
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&req); err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID))
+
+			Error(w, r, http.StatusBadRequest, decodeError(err))
+			return
+		}
+		defer r.Body.Close()
+
+		if req.Name == "" && req.Limits == (models.WalletTypeLimits{}) && len(req.Lift) == 0 {
+			Error(w, r, http.StatusBadRequest, ErrNothingToUpdate)
+			return
+		}
+		if utf8.RuneCountInString(req.Name) > 100 {
+			log.Warn(ErrWalletTypeNameTooLong.Error(), logger.String("X-OperatorId", operatorID), logger.Int("wallet_type", id))
+
+			Error(w, r, http.StatusBadRequest, ErrWalletTypeNameTooLong)
+			return
+		}
+		currency, err := limitsCurrency(req.Currency)
+		if err != nil {
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := validateLimits(req.Limits, req.Lift, currency); err != nil {
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		typeReq := models.WalletTypeReq{
+			ID:            id,
+			Name:          req.Name,
+			Currency:      currency.Code,
+			Limits:        req.Limits,
+			Lift:          req.Lift,
+			EffectiveFrom: time.Now(),
+			Force:         req.Force,
+			OperatorID:    operatorID,
+		}
+		if req.EffectiveFrom != nil {
+			if req.EffectiveFrom.Before(typeReq.EffectiveFrom) {
+				Error(w, r, http.StatusBadRequest, ErrEffectiveFromInPast)
+				return
+			}
+			typeReq.EffectiveFrom = *req.EffectiveFrom
+		}
+
+		resp, err := h.svc.UpdateWalletType(r.Context(), &typeReq)
+		if errors.Is(err, customerrors.ErrWalletTypeNotFound) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("wallet_type", id))
+
+			Error(w, r, http.StatusNotFound, customerrors.ErrWalletTypeNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrWalletTypeExists) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("wallet_type", id))
+
+			Error(w, r, http.StatusConflict, customerrors.ErrWalletTypeExists)
+			return
+		}
+		var capErr customerrors.ErrBalancesAboveCap
+		if errors.As(err, &capErr) {
+			log.Warn(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("wallet_type", id))
+
+			Error(w, r, http.StatusConflict, capErr)
+			return
+		}
+		if err != nil {
+			log.Error(err.Error(), logger.String("X-OperatorId", operatorID), logger.Int("wallet_type", id))
+
+			Error(w, r, http.StatusInternalServerError, nil)
+			return
+		}
+
+		log.Info("wallet type updated",
+			logger.String("X-OperatorId", operatorID),
+			logger.Int("wallet_type", id),
+			logger.Any("effective_from", typeReq.EffectiveFrom),
+		)
+
+		Respond(w, r, http.StatusOK, resp)
+	}
+}
+
+// limitsCurrency returns the currency the limits of the request are given
+// in, TJS if it's omitted
+func limitsCurrency(code string) (money.Currency, error) {
+	if code == "" {
+		code = money.TJS
+	}
+	currency, err := money.LookupCurrency(code)
+	if err != nil {
+		return money.Currency{}, ErrInvalidCurrency
+	}
+
+	return currency, nil
+}
+
+// validateLimits checks the limits and the rules to lift of the request on
+// their own, without the limits already set. Amounts must fit the currency
+func validateLimits(limits models.WalletTypeLimits, lift []string, currency money.Currency) error {
+	set := make(map[string]bool)
+	amounts := make(map[string]money.Amount)
+	for rule, amount := range map[string]*money.Decimal{
+		models.RuleMinAmount:    limits.MinAmount,
+		models.RuleMaxAmount:    limits.MaxAmount,
+		models.RuleDailyTopUp:   limits.DailyTopUp,
+		models.RuleMonthlyTopUp: limits.MonthlyTopUp,
+		models.RuleMaxBalance:   limits.MaxBalance,
+	} {
+		if amount == nil {
+			continue
+		}
+		minor, err := amount.Amount(currency)
+		if err != nil {
+			return err
+		}
+		if minor < 0 {
+			return ErrNegativeLimit
+		}
+		amounts[rule] = minor
+		set[rule] = true
+	}
+	if limits.DailyOperations != nil {
+		if *limits.DailyOperations < 0 {
+			return ErrNegativeLimit
+		}
+		set[models.RuleDailyOperations] = true
+	}
+
+	if set[models.RuleMinAmount] && set[models.RuleMaxAmount] && amounts[models.RuleMinAmount] > amounts[models.RuleMaxAmount] {
+		return ErrMinAboveMax
+	}
+
+	for _, rule := range lift {
+		switch rule {
+		case models.RuleMinAmount, models.RuleMaxAmount, models.RuleDailyTopUp,
+			models.RuleMonthlyTopUp, models.RuleDailyOperations:
+			if set[rule] {
+				return ErrInvalidLift
+			}
+		default:
+			return ErrInvalidLift
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/parviz-yu/digital-wallet/internal/config"
+	"github.com/parviz-yu/digital-wallet/internal/service"
+	"github.com/parviz-yu/digital-wallet/internal/storage/postgres"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+)
+
+// exit codes, so ops can tell drift from a failed run
+const (
+	exitDrift  = 1
+	exitFailed = 2
+)
+
+// reconcile compares stored wallet balances with the transaction log once
+// and exits non-zero if any wallet drifted
+func main() {
+	cfg := config.MustLoad()
+
+	log := logger.NewLogger(cfg.Env)
+
+	strg, err := postgres.NewStorage(context.Background(), cfg)
+	if err != nil {
+		log.Error("failed to init storage", logger.Error(err))
+		os.Exit(exitFailed)
+	}
+
+	svc := service.NewService(cfg, log, strg)
+
+	run, err := svc.Reconcile(context.Background())
+	strg.CloseDB()
+	if err != nil {
+		log.Error("failed to reconcile balances", logger.Error(err))
+		os.Exit(exitFailed)
+	}
+
+	if len(run.Drifts) > 0 {
+		os.Exit(exitDrift)
+	}
+}
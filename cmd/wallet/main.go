@@ -58,8 +58,19 @@ func main() {
 
 	log.Info("server started")
 
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	if cfg.ReconcileInterval > 0 {
+		go reconcilePeriodically(jobCtx, svc, log, cfg.ReconcileInterval)
+	}
+	if cfg.NoncePurgeInterval > 0 {
+		go purgeNoncesPeriodically(jobCtx, svc, log, cfg.NoncePurgeInterval)
+	}
+
 	<-done
 	log.Info("stopping server...")
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -71,3 +82,39 @@ func main() {
 
 	log.Info("server stopped")
 }
+
+// reconcilePeriodically reconciles wallet balances with the transaction log
+// until ctx is done. Drifts are logged by the service
+func reconcilePeriodically(ctx context.Context, svc service.ServiceI, log logger.LoggerI, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := svc.Reconcile(ctx); err != nil {
+				log.Error("failed to reconcile balances", logger.Error(err))
+			}
+		}
+	}
+}
+
+// purgeNoncesPeriodically removes expired request nonces until ctx is done,
+// keeping the table small without a delete on every signed request
+func purgeNoncesPeriodically(ctx context.Context, svc service.ServiceI, log logger.LoggerI, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := svc.PurgeNonces(ctx); err != nil {
+				log.Error("failed to purge nonces", logger.Error(err))
+			}
+		}
+	}
+}
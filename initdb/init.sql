@@ -1,46 +1,379 @@
-SET TIMEZONE='Asia/Dushanbe';
-
+-- wallet types, their limits are kept in limit_rules
 CREATE TABLE limits (
+    id SERIAL PRIMARY KEY NOT NULL,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE partners (
     id SERIAL PRIMARY KEY NOT NULL,
     name VARCHAR(100) NOT NULL,
-    max_amount  BIGINT NOT NULL
+    signature_alg VARCHAR(20) NOT NULL DEFAULT 'hmac-sha1'
+        CHECK (signature_alg IN ('hmac-sha1', 'hmac-sha256', 'hmac-sha512')),
+    -- fee of top-ups and withdrawals paid by the partner, in basis points
+    fee_bps INT NOT NULL DEFAULT 0 CHECK (fee_bps BETWEEN 0 AND 1000),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- a partner can hold several keys with overlapping validity while rotating them
+CREATE TABLE partner_keys (
+    id SERIAL PRIMARY KEY NOT NULL,
+    partner_id INT NOT NULL,
+    key_id VARCHAR(64) NOT NULL,
+    secret_key VARCHAR(255) NOT NULL,
+    not_before TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    not_after TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    UNIQUE (partner_id, key_id),
+    FOREIGN KEY (partner_id) REFERENCES partners(id)
+);
+
+-- nonces of signed requests, kept while their timestamps are acceptable
+CREATE TABLE request_nonces (
+    partner_id INT NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (partner_id, nonce),
+    FOREIGN KEY (partner_id) REFERENCES partners(id)
 );
 
+CREATE INDEX request_nonces_expires_at_idx ON request_nonces(expires_at);
+
+-- a user can hold several wallets, requests without a wallet id in the path
+-- are made for the user's default one
 CREATE TABLE wallets (
     id SERIAL PRIMARY KEY NOT NULL,
-    balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    public_id VARCHAR(36) NOT NULL UNIQUE DEFAULT gen_random_uuid()::text, -- wallet id shown to partners
+    name VARCHAR(100),
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0), -- in minor units of the currency
+    currency CHAR(3) NOT NULL DEFAULT 'TJS', -- ISO 4217 code, never changes
     type INT NOT NULL DEFAULT 1,
-    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-    user_id CHAR(36) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    user_id CHAR(36) NOT NULL,
+    partner_id INT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'frozen_credit', 'frozen_debit', 'blocked', 'closed')),
+    status_reason VARCHAR(30) CHECK (status_reason IN (
+        'customer_request', 'fraud_suspected', 'compromised', 'deceased',
+        'court_order', 'compliance_review', 'review_completed', 'other'
+    )),
+    status_changed_at TIMESTAMPTZ,
+
+    FOREIGN KEY (type) REFERENCES limits(id),
+    FOREIGN KEY (partner_id) REFERENCES partners(id)
+);
+
+CREATE INDEX wallets_partner_id_user_id_idx ON wallets(partner_id, user_id);
+CREATE UNIQUE INDEX wallets_default_idx ON wallets(partner_id, user_id) WHERE is_default;
+
+-- limits set per wallet type and currency and overridden per wallet. Amounts
+-- are in minor units of the currency, daily_operations is a number of
+-- operations. A rule is changed by adding its version, the latest one whose
+-- effective_from has come is applied
+CREATE TABLE limit_rules (
+    id SERIAL PRIMARY KEY NOT NULL,
+    wallet_type INT,
+    currency CHAR(3), -- set with wallet_type, overrides are in the wallet's currency
+    wallet_id INT,
+    rule VARCHAR(30) NOT NULL CHECK (rule IN (
+        'min_amount', 'max_amount', 'daily_top_up', 'monthly_top_up', 'daily_operations', 'max_balance'
+    )),
+    value BIGINT CHECK (value >= 0), -- NULL lifts the rule
+    effective_from TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 
-    FOREIGN KEY (type) REFERENCES limits(id)
+    CHECK ((wallet_type IS NULL) <> (wallet_id IS NULL)),
+    CHECK ((wallet_type IS NULL) = (currency IS NULL)),
+    FOREIGN KEY (wallet_type) REFERENCES limits(id),
+    FOREIGN KEY (wallet_id) REFERENCES wallets(id)
+);
+
+CREATE UNIQUE INDEX limit_rules_wallet_type_idx
+    ON limit_rules(wallet_type, currency, rule, effective_from) WHERE wallet_type IS NOT NULL;
+CREATE UNIQUE INDEX limit_rules_wallet_id_idx
+    ON limit_rules(wallet_id, rule, effective_from) WHERE wallet_id IS NOT NULL;
+
+CREATE TABLE transfers (
+    id SERIAL PRIMARY KEY NOT NULL,
+    from_wallet_id INT NOT NULL,
+    to_wallet_id INT NOT NULL CHECK (to_wallet_id <> from_wallet_id),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (from_wallet_id) REFERENCES wallets(id),
+    FOREIGN KEY (to_wallet_id) REFERENCES wallets(id)
 );
 
 CREATE TABLE transactions (
     id SERIAL PRIMARY KEY NOT NULL,
     wallet_id INT NOT NULL,
     amount BIGINT NOT NULL CHECK (amount > 0),
-    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    currency CHAR(3) NOT NULL, -- the wallet's currency
+    type VARCHAR(20) NOT NULL DEFAULT 'top_up'
+        CHECK (type IN ('top_up', 'withdrawal', 'transfer_in', 'transfer_out', 'reversal')),
+    transfer_id INT,
+    original_id INT, -- the top-up refunded by a reversal
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CHECK ((type = 'reversal') = (original_id IS NOT NULL)),
+    FOREIGN KEY (wallet_id) REFERENCES wallets(id),
+    FOREIGN KEY (transfer_id) REFERENCES transfers(id),
+    FOREIGN KEY (original_id) REFERENCES transactions(id)
+);
+
+-- balances of closed wallets paid out to the customer, each recorded as
+-- a withdrawal
+CREATE TABLE payouts (
+    id SERIAL PRIMARY KEY NOT NULL,
+    wallet_id INT NOT NULL,
+    transaction_id INT NOT NULL UNIQUE,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    destination VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (wallet_id) REFERENCES wallets(id),
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id)
+);
+
+CREATE INDEX transactions_original_id_idx ON transactions(original_id) WHERE original_id IS NOT NULL;
+
+-- the history is paged by (created_at, id) within a wallet
+CREATE INDEX transactions_wallet_id_created_at_idx ON transactions(wallet_id, created_at, id);
+
+-- double-entry ledger: every journal entry moves money between accounts of
+-- the same currency with postings summing to zero, so the ledger of every
+-- currency always sums to zero. Credits of an account are positive, debits
+-- negative. Wallet accounts cache their balance, the wallet row is locked by
+-- the operation anyway. A settlement account is shared by all operations of
+-- the partner in its currency, and the fee and suspense accounts by all
+-- operations in theirs, so their balances are only the sums of their postings
+-- and the rows are never updated. Fees paid by partners go to the fee
+-- account; money without an account to go to and differences found by
+-- reconciliation are parked in the suspense account
+CREATE TABLE accounts (
+    id SERIAL PRIMARY KEY NOT NULL,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('wallet', 'settlement', 'fee', 'suspense')),
+    currency CHAR(3) NOT NULL,
+    partner_id INT,
+    wallet_id INT UNIQUE,
+    balance BIGINT, -- cached sum of the postings of a wallet account
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 
+    CHECK ((type = 'wallet') = (wallet_id IS NOT NULL)),
+    CHECK ((type = 'wallet') = (balance IS NOT NULL)),
+    CHECK ((type IN ('wallet', 'settlement')) = (partner_id IS NOT NULL)),
+    FOREIGN KEY (partner_id) REFERENCES partners(id),
     FOREIGN KEY (wallet_id) REFERENCES wallets(id)
 );
 
-INSERT INTO limits (name, max_amount)
+CREATE UNIQUE INDEX accounts_settlement_idx ON accounts(partner_id, currency) WHERE type = 'settlement';
+CREATE UNIQUE INDEX accounts_system_idx ON accounts(type, currency) WHERE type IN ('fee', 'suspense');
+
+-- a new partner gets settlement accounts in every currency wallet types have
+-- limits in. A currency added to a wallet type later opens them for every
+-- partner from the service
+CREATE FUNCTION open_settlement_accounts() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO accounts (type, currency, partner_id)
+    SELECT DISTINCT 'settlement', currency, NEW.id FROM limit_rules WHERE currency IS NOT NULL
+    ON CONFLICT (partner_id, currency) WHERE type = 'settlement' DO NOTHING;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER partners_settlement_accounts
+    AFTER INSERT ON partners
+    FOR EACH ROW EXECUTE FUNCTION open_settlement_accounts();
+
+CREATE TABLE journal_entries (
+    id SERIAL PRIMARY KEY NOT NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('top_up', 'withdrawal', 'transfer', 'reversal', 'reconciliation')),
+    reference VARCHAR(64) NOT NULL, -- the operation recorded by the entry
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE postings (
+    id SERIAL PRIMARY KEY NOT NULL,
+    entry_id INT NOT NULL,
+    account_id INT NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount <> 0),
+
+    FOREIGN KEY (entry_id) REFERENCES journal_entries(id),
+    FOREIGN KEY (account_id) REFERENCES accounts(id)
+);
+
+CREATE INDEX postings_entry_id_idx ON postings(entry_id);
+CREATE INDEX postings_account_id_idx ON postings(account_id);
+
+-- entries are checked on commit, after all of their postings are added
+CREATE FUNCTION check_entry_balanced() RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is unbalanced', NEW.entry_id;
+    END IF;
+    IF (SELECT COUNT(DISTINCT a.currency) FROM postings p JOIN accounts a ON a.id = p.account_id
+        WHERE p.entry_id = NEW.entry_id) > 1 THEN
+        RAISE EXCEPTION 'journal entry % mixes currencies', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT OR UPDATE ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_entry_balanced();
+
+CREATE TABLE identification_requests (
+    id SERIAL PRIMARY KEY NOT NULL,
+    wallet_id INT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    full_name VARCHAR(255) NOT NULL,
+    document_type VARCHAR(50) NOT NULL,
+    document_number VARCHAR(50) NOT NULL,
+    birth_date DATE NOT NULL,
+    reviewed_by VARCHAR(100),
+    reject_reason VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMPTZ,
+
+    FOREIGN KEY (wallet_id) REFERENCES wallets(id)
+);
+
+-- a wallet can have only one request waiting for review
+CREATE UNIQUE INDEX identification_requests_pending_idx
+    ON identification_requests(wallet_id) WHERE status = 'pending';
+
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(50) NOT NULL,
+    details JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) NOT NULL,
+    partner_id INT NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INT,
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (partner_id, user_id, key)
+);
+
+-- results of comparing stored wallet balances with the transaction log
+CREATE TABLE reconciliation_runs (
+    id SERIAL PRIMARY KEY NOT NULL,
+    wallets_checked INT NOT NULL,
+    drifts INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE balance_drifts (
+    id SERIAL PRIMARY KEY NOT NULL,
+    run_id INT NOT NULL,
+    wallet_id INT NOT NULL,
+    balance BIGINT NOT NULL,
+    expected BIGINT NOT NULL, -- balance according to the transaction log
+    entry_id INT, -- moves the difference with the ledger to the suspense account
+
+    FOREIGN KEY (run_id) REFERENCES reconciliation_runs(id),
+    FOREIGN KEY (wallet_id) REFERENCES wallets(id),
+    FOREIGN KEY (entry_id) REFERENCES journal_entries(id)
+);
+
+INSERT INTO limits (name)
+VALUES
+    ('unidentified wallet'),
+    ('identified wallet');
+
+INSERT INTO limit_rules (wallet_type, currency, rule, value)
+VALUES
+    (1, 'TJS', 'min_amount', 100),
+    (1, 'TJS', 'max_amount', 500000),
+    (1, 'TJS', 'daily_top_up', 1000000),
+    (1, 'TJS', 'monthly_top_up', 3000000),
+    (1, 'TJS', 'daily_operations', 20),
+    (1, 'TJS', 'max_balance', 1000000),
+    (2, 'TJS', 'min_amount', 100),
+    (2, 'TJS', 'max_amount', 5000000),
+    (2, 'TJS', 'daily_top_up', 10000000),
+    (2, 'TJS', 'monthly_top_up', 30000000),
+    (2, 'TJS', 'daily_operations', 100),
+    (2, 'TJS', 'max_balance', 10000000),
+    (1, 'USD', 'min_amount', 100),
+    (1, 'USD', 'max_amount', 50000),
+    (1, 'USD', 'daily_top_up', 100000),
+    (1, 'USD', 'monthly_top_up', 300000),
+    (1, 'USD', 'daily_operations', 20),
+    (1, 'USD', 'max_balance', 100000),
+    (2, 'USD', 'min_amount', 100),
+    (2, 'USD', 'max_amount', 500000),
+    (2, 'USD', 'daily_top_up', 1000000),
+    (2, 'USD', 'monthly_top_up', 3000000),
+    (2, 'USD', 'daily_operations', 100),
+    (2, 'USD', 'max_balance', 1000000);
+
+INSERT INTO partners (name, signature_alg, fee_bps)
+VALUES
+    ('first partner', 'hmac-sha1', 0),
+    ('second partner', 'hmac-sha256', 50);
+
+-- every currency wallet types have limits in gets its fee and suspense accounts
+INSERT INTO accounts (type, currency)
+SELECT t.type, r.currency
+FROM (SELECT DISTINCT currency FROM limit_rules WHERE currency IS NOT NULL) r
+CROSS JOIN (VALUES ('fee'), ('suspense')) t(type);
+
+INSERT INTO partner_keys (partner_id, key_id, secret_key)
 VALUES
-    ('unidentified wallet', 1000000),
-    ('identified wallet', 10000000);
+    (1, 'v1', 'secret'),
+    (2, 'v1', 'another-secret');
 
-INSERT INTO wallets (balance, type, user_id)
+INSERT INTO wallets (public_id, is_default, balance, type, user_id, partner_id)
 VALUES
-    (50000, 1, '36764dc2-2653-4e7f-b24c-430deca66b88'),
-    (150000, 2, 'c76fdd66-3d0c-4633-8274-c12f67e4fa2a'),
-    (510000, 1, '1c6287a0-7071-4b63-af89-24a87ce89599'),
-    (30000, 2, '69bccb14-69f8-48c8-b123-f80d65e6927f'),
-    (0, 2, 'd136f61a-6a4c-4029-8bc6-6b722b80e0b3');
+    ('5f0c7e2a-8d41-4b6e-9a3f-2c7d1e8b4a60', TRUE, 50000, 1, '36764dc2-2653-4e7f-b24c-430deca66b88', 1),
+    ('b81e4d93-2f6a-4c0d-8e75-9a1b3c5d7e02', TRUE, 150000, 2, 'c76fdd66-3d0c-4633-8274-c12f67e4fa2a', 1),
+    ('0d6a9f31-7c2e-4e58-b4a1-6f8e2d0c9b17', TRUE, 510000, 1, '1c6287a0-7071-4b63-af89-24a87ce89599', 1),
+    ('e4b72c18-93d5-4a0f-8c6e-1d2f3a4b5c6d', TRUE, 30000, 2, '69bccb14-69f8-48c8-b123-f80d65e6927f', 2),
+    ('7a3d5e91-0b4c-4f26-9d8e-5c1a2b3e4f70', TRUE, 0, 2, 'd136f61a-6a4c-4029-8bc6-6b722b80e0b3', 2);
 
-INSERT INTO transactions (wallet_id, amount)
+INSERT INTO transactions (wallet_id, amount, currency)
 VALUES
-    (1, 50000),
-    (2, 150000),
-    (3, 510000),
-    (4, 30000);
\ No newline at end of file
+    (1, 50000, 'TJS'),
+    (2, 150000, 'TJS'),
+    (3, 510000, 'TJS'),
+    (4, 30000, 'TJS');
+
+-- settlement accounts of the partners are opened by partners_settlement_accounts
+INSERT INTO accounts (type, currency, partner_id, wallet_id, balance)
+SELECT 'wallet', currency, partner_id, id, 0 FROM wallets;
+
+-- the seeded top-ups are funded from the partners' settlement accounts
+INSERT INTO journal_entries (kind, reference)
+SELECT 'top_up', 'transaction:' || id FROM transactions ORDER BY id;
+
+INSERT INTO postings (entry_id, account_id, amount)
+SELECT e.id, a.id, t.amount
+FROM journal_entries e
+JOIN transactions t ON e.reference = 'transaction:' || t.id
+JOIN accounts a ON a.wallet_id = t.wallet_id
+UNION ALL
+SELECT e.id, a.id, -t.amount
+FROM journal_entries e
+JOIN transactions t ON e.reference = 'transaction:' || t.id
+JOIN wallets w ON w.id = t.wallet_id
+JOIN accounts a ON a.type = 'settlement' AND a.partner_id = w.partner_id AND a.currency = w.currency;
+
+UPDATE accounts a SET balance = (SELECT COALESCE(SUM(amount), 0) FROM postings p WHERE p.account_id = a.id)
+WHERE a.type = 'wallet';
\ No newline at end of file
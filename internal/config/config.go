@@ -3,14 +3,29 @@ package config
 import (
 	"log"
 	"time"
+	_ "time/tzdata" // the runtime image has no timezone database
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	SecretToket string `env:"SECRET_TOKEN" env-required:"true"`
-	Env         string `yaml:"env" env-default:"local"`
-	HTTPServer         //`yaml:"http_server"`
+	AdminToken     string        `env:"ADMIN_TOKEN" env-required:"true"`
+	Env            string        `yaml:"env" env-default:"local"`
+	IdempotencyTTL time.Duration `env:"IDEMPOTENCY_TTL" env-default:"24h"`
+	// signed requests are accepted only if X-Timestamp differs from
+	// the server time by no more than SignatureMaxSkew
+	SignatureMaxSkew time.Duration `env:"SIGNATURE_MAX_SKEW" env-default:"5m"`
+	// HMAC-SHA1 signatures are rejected once every partner has migrated
+	SignatureAllowSHA1 bool `env:"SIGNATURE_ALLOW_SHA1" env-default:"true"`
+	// months, weeks and days of the stats start at midnight of this timezone
+	BusinessTimezone string `env:"BUSINESS_TIMEZONE" env-default:"Asia/Dushanbe"`
+	businessLocation *time.Location
+	// wallet balances are reconciled with the transaction log this often,
+	// zero disables the in-process job
+	ReconcileInterval time.Duration `env:"RECONCILE_INTERVAL" env-default:"0"`
+	// expired request nonces are purged this often, zero disables the job
+	NoncePurgeInterval time.Duration `env:"NONCE_PURGE_INTERVAL" env-default:"1m"`
+	HTTPServer                       //`yaml:"http_server"`
 	Database
 }
 
@@ -19,6 +34,7 @@ type HTTPServer struct {
 	Port        string        `env:"SERVER_PORT" env-default:"8080"`
 	Timeout     time.Duration `env:"SERVER_TIMEOUT" env-default:"4s"`
 	IdleTimeout time.Duration `env:"SERVER_IDLETIMEOUT" env-default:"45s"`
+	MaxBodySize int64         `env:"SERVER_MAX_BODY_SIZE" env-default:"65536"` // bytes
 }
 
 type Database struct {
@@ -27,8 +43,18 @@ type Database struct {
 	PostgresUser     string `env:"POSTGRES_USER" env-default:"postgres"`
 	PostgresPassword string `env:"POSTGRES_PASSWORD" env-required:"true"`
 	PostgresDatabase string `env:"POSTGRES_DATABASE" env-default:"postgres"`
+	// serialization failures of the transactions are retried with
+	// exponential backoff starting from TxRetryBackoff and capped at
+	// TxMaxBackoff
+	TxMaxRetries   int           `env:"TX_MAX_RETRIES" env-default:"5"`
+	TxRetryBackoff time.Duration `env:"TX_RETRY_BACKOFF" env-default:"10ms"`
+	TxMaxBackoff   time.Duration `env:"TX_MAX_BACKOFF" env-default:"1s"`
 }
 
+// MaxTxRetries bounds TxMaxRetries, so a request can't keep retrying its
+// transaction for long
+const MaxTxRetries = 20
+
 func MustLoad() Config {
 	// configPath := os.Getenv("CONFIG_PATH")
 	// if configPath == "" {
@@ -48,5 +74,27 @@ func MustLoad() Config {
 		log.Fatalf("can't read config: %s", err)
 	}
 
+	if cfg.TxMaxRetries < 0 || cfg.TxMaxRetries > MaxTxRetries {
+		log.Fatalf("TX_MAX_RETRIES must be from 0 to %d", MaxTxRetries)
+	}
+	if cfg.TxRetryBackoff < 0 || cfg.TxMaxBackoff < cfg.TxRetryBackoff {
+		log.Fatalf("TX_RETRY_BACKOFF must be from 0 to TX_MAX_BACKOFF")
+	}
+
+	location, err := time.LoadLocation(cfg.BusinessTimezone)
+	if err != nil {
+		log.Fatalf("can't load business timezone: %s", err)
+	}
+	cfg.businessLocation = location
+
 	return cfg
 }
+
+// BusinessLocation returns the location of BusinessTimezone
+func (c Config) BusinessLocation() *time.Location {
+	if c.businessLocation == nil {
+		return time.UTC
+	}
+
+	return c.businessLocation
+}
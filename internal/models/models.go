@@ -1,44 +1,616 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// Transaction types stored in transactions.type
+const (
+	TxTypeTopUp       = "top_up"
+	TxTypeWithdrawal  = "withdrawal"
+	TxTypeTransferIn  = "transfer_in"
+	TxTypeTransferOut = "transfer_out"
+	TxTypeReversal    = "reversal"
+)
+
+// Ledger account types stored in accounts.type
+const (
+	AccountWallet     = "wallet"
+	AccountSettlement = "settlement"
+	AccountFee        = "fee"      // fees charged to partners, one per currency
+	AccountSuspense   = "suspense" // money not matched to an account yet, one per currency
+)
+
+// Journal entry kinds stored in journal_entries.kind
+const (
+	EntryTopUp      = "top_up"
+	EntryWithdrawal = "withdrawal"
+	EntryTransfer   = "transfer"
+	EntryReversal   = "reversal"
+	// moves the difference found by reconciliation to the suspense account
+	EntryReconciliation = "reconciliation"
+)
+
+// Stats buckets of the group_by parameter
+const (
+	StatsByDay   = "day"
+	StatsByWeek  = "week"
+	StatsByMonth = "month"
+)
+
+// DateLayout is the format of dates in requests and responses
+const DateLayout = "2006-01-02"
+
+// Wallet types, ids of the limits table rows
+const (
+	WalletTypeUnidentified = 1
+	WalletTypeIdentified   = 2
+)
+
+// Limit rules stored in limit_rules.rule
+const (
+	RuleMinAmount       = "min_amount"       // smallest amount of an operation
+	RuleMaxAmount       = "max_amount"       // largest amount of an operation
+	RuleDailyTopUp      = "daily_top_up"     // incoming turnover of a day
+	RuleMonthlyTopUp    = "monthly_top_up"   // incoming turnover of a month
+	RuleDailyOperations = "daily_operations" // operations made by the wallet in a day
+	RuleMaxBalance      = "max_balance"
+)
+
+// Wallet statuses stored in wallets.status
+const (
+	WalletActive       = "active"
+	WalletFrozenCredit = "frozen_credit" // money can't come in
+	WalletFrozenDebit  = "frozen_debit"  // money can't go out
+	WalletBlocked      = "blocked"       // money can't move at all
+	WalletClosed       = "closed"        // for good, the balance is zero
+)
+
+// Reasons of wallet status changes stored in wallets.status_reason
+const (
+	ReasonCustomerRequest  = "customer_request"
+	ReasonFraudSuspected   = "fraud_suspected"
+	ReasonCompromised      = "compromised"
+	ReasonDeceased         = "deceased"
+	ReasonCourtOrder       = "court_order"
+	ReasonComplianceReview = "compliance_review"
+	ReasonReviewCompleted  = "review_completed"
+	ReasonOther            = "other"
+)
+
+// Identification request statuses
+const (
+	IdentificationPending  = "pending"
+	IdentificationApproved = "approved"
+	IdentificationRejected = "rejected"
+)
+
+type Partner struct {
+	ID           int
+	Name         string
+	SignatureAlg string       // used when the request has no X-Digest-Alg header
+	FeeBPS       int          // fee of top-ups and withdrawals, in basis points of the amount
+	Keys         []PartnerKey // keys the request can be signed with
+}
+
+type PartnerKey struct {
+	ID        int
+	PartnerID int
+	KeyID     string
+	SecretKey string
+	NotBefore time.Time
+	NotAfter  *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// WalletOwner identifies a wallet by the partner, the partner's user and the
+// public id of the user's wallet. Without the id it's the user's default wallet
+type WalletOwner struct {
+	PartnerID int
+	UserID    string
+	WalletID  string
+}
 
 type Wallet struct {
-	ID      int
-	Balance int // smalles unit (diram)
-	Type    int
+	ID        int
+	PublicID  string
+	PartnerID int
+	UserID    string
+	Name      string
+	IsDefault bool
+	Balance   money.Amount
+	Currency  string
+	Type      int
+	TypeName  string
+	Status    string
+}
+
+// WalletStatusChange moves the wallet to Status for the Reason
+type WalletStatusChange struct {
+	WalletID int
+	Status   string
+	Reason   string
+}
+
+// Payout pays the balance of the closed wallet out to the destination
+type Payout struct {
+	WalletID      int
+	TransactionID int
+	Amount        money.Amount
+	Destination   string
 }
 
 type Payment struct {
-	Amount   int // smalles unit (diram)
+	Amount   money.Amount
+	WalletID int
+}
+
+type Transfer struct {
+	Amount       money.Amount
+	FromWalletID int
+	ToWalletID   int
+}
+
+// Transaction is a wallet operation in the history
+type Transaction struct {
+	ID                 int
+	WalletID           int
+	Type               string
+	Amount             money.Amount
+	Currency           string
+	Counterparty       string // user id of the other wallet of a transfer
+	CounterpartyWallet string // public id of the other wallet of a transfer
+	TransferID         int
+	OriginalID         int          // the top-up refunded by a reversal
+	Reversed           money.Amount // refunded part of a top-up
+	CreatedAt          time.Time
+}
+
+// Reversal refunds a part of the top-up
+type Reversal struct {
+	WalletID   int
+	OriginalID int
+	Amount     money.Amount
+}
+
+// TransactionCursor is the position of the last transaction of a history page
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// TransactionFilter selects wallet operations newest first. Zero fields
+// aren't filtered by
+type TransactionFilter struct {
+	WalletID  int
+	From      time.Time // inclusive
+	To        time.Time // exclusive
+	Type      string
+	MinAmount money.Amount
+	MaxAmount money.Amount
+	After     *TransactionCursor
+	Limit     int
+}
+
+// Account is a ledger account. Only wallet accounts cache their balance
+type Account struct {
+	ID      int
+	Balance money.Amount
+}
+
+// Posting changes the balance of the account, credits are positive and
+// debits negative
+type Posting struct {
+	AccountID int
+	Amount    money.Amount
+}
+
+// JournalEntry records a single operation, its postings sum to zero
+type JournalEntry struct {
+	ID        int
+	Kind      string
+	Reference string
+	Postings  []Posting
+}
+
+// LedgerCheck is the result of checking the ledger invariants
+type LedgerCheck struct {
+	Totals            map[string]money.Amount // sums of postings by currency
+	UnbalancedEntries []int
+	AccountMismatches []int // accounts whose cached balance differs from their postings
+	WalletMismatches  []int // wallets whose balance differs from their account
+}
+
+// BalanceDrift is a wallet whose stored balance differs from its
+// transaction log
+type BalanceDrift struct {
 	WalletID int
+	Currency string
+	Balance  money.Amount
+	Expected money.Amount
+	EntryID  int // entry moving the difference with the ledger to suspense, zero if there's none
 }
 
+type ReconciliationRun struct {
+	ID             int
+	WalletsChecked int
+	Drifts         []BalanceDrift
+}
+
+// WalletStatsRange is the half-open range [DateBegin, DateEnd) of the stats
 type WalletStatsRange struct {
 	DateBegin time.Time
 	DateEnd   time.Time
 	WalletID  int
+	GroupBy   string         // bucket of the series, one of StatsBy*
+	Location  *time.Location // buckets start at midnight of this location
 }
 
 type WalletStatResult struct {
 	Number int
-	Amount int
+	Amount money.Amount
+}
+
+// WalletStatsBucket is the result of a single bucket of the stats series
+type WalletStatsBucket struct {
+	Start  time.Time
+	Number int
+	Amount money.Amount
 }
 
-type Limit struct {
+// WalletType is a row of the limits table
+type WalletType struct {
+	ID        int
 	Name      string
-	MaxAmount int
+	CreatedAt time.Time
+}
+
+// LimitRule is a limit applied to the wallet, an amount in minor units of
+// the wallet's currency or a number of operations for RuleDailyOperations
+type LimitRule struct {
+	Rule  string
+	Value int64
 }
 
+// LimitRuleChange sets the rule of the wallet type in the currency, or the
+// wallet's own rule if WalletID is set, from EffectiveFrom on. Nil Value
+// lifts the rule
+type LimitRuleChange struct {
+	WalletType    int
+	Currency      string
+	WalletID      int
+	Rule          string
+	Value         *int64
+	EffectiveFrom time.Time
+}
+
+// Turnover is what the wallet has done so far in the current day and month
+type Turnover struct {
+	DailyIncoming   money.Amount
+	MonthlyIncoming money.Amount
+	DailyOperations int
+}
+
+type Identification struct {
+	ID             int
+	WalletID       int
+	UserID         string
+	Status         string
+	FullName       string
+	DocumentType   string
+	DocumentNumber string
+	BirthDate      time.Time
+	ReviewedBy     string
+	RejectReason   string
+	CreatedAt      time.Time
+}
+
+// Audit actions stored in audit_log.action
+const (
+	AuditWalletCreated           = "wallet_created"
+	AuditIdentificationSubmitted = "identification_submitted"
+	AuditIdentificationApproved  = "identification_approved"
+	AuditIdentificationRejected  = "identification_rejected"
+	AuditPartnerKeyCreated       = "partner_key_created"
+	AuditPartnerKeyRevoked       = "partner_key_revoked"
+	AuditTransactionReversed     = "transaction_reversed"
+	AuditWalletTypeCreated       = "wallet_type_created"
+	AuditWalletTypeUpdated       = "wallet_type_updated"
+	AuditWalletStatusChanged     = "wallet_status_changed"
+	AuditWalletLimitsUpdated     = "wallet_limits_updated"
+	AuditWalletLimitDeleted      = "wallet_limit_deleted"
+)
+
+type AuditRecord struct {
+	Actor    string
+	Action   string
+	Entity   string
+	EntityID string
+	Details  map[string]any
+}
+
+type IdempotencyKey struct {
+	Key          string
+	PartnerID    int
+	UserID       string
+	RequestHash  string
+	StatusCode   int // zero until the operation made with the key commits
+	ResponseBody []byte
+	ExpiresAt    time.Time
+}
+
+type CreateWalletReq struct {
+	Owner    WalletOwner
+	Type     int
+	Name     string
+	Currency string
+}
+
+type CreateWalletResp struct {
+	WalletID string        `json:"wallet_id"`
+	Name     string        `json:"name,omitempty"`
+	Default  bool          `json:"default"`
+	Type     string        `json:"type"`
+	Balance  money.Decimal `json:"balance"`
+	Currency string        `json:"currency"`
+}
+
+// WalletItemResp is a wallet in the list of the user's wallets
+type WalletItemResp struct {
+	WalletID string        `json:"wallet_id"`
+	Name     string        `json:"name,omitempty"`
+	Default  bool          `json:"default"`
+	Type     string        `json:"type"`
+	Balance  money.Decimal `json:"balance"`
+	Currency string        `json:"currency"`
+	Status   string        `json:"status"`
+}
+
+type IdentificationReq struct {
+	Owner          WalletOwner
+	FullName       string
+	DocumentType   string
+	DocumentNumber string
+	BirthDate      time.Time
+}
+
+type IdentificationReview struct {
+	ID         int
+	OperatorID string
+	Approve    bool
+	Reason     string
+}
+
+type IdentificationResp struct {
+	ID             int       `json:"id"`
+	UserID         string    `json:"user_id"`
+	Status         string    `json:"status"`
+	FullName       string    `json:"full_name"`
+	DocumentType   string    `json:"document_type"`
+	DocumentNumber string    `json:"document_number"`
+	BirthDate      string    `json:"birth_date"`
+	RejectReason   string    `json:"reject_reason,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// WalletTypeLimits are limits of a wallet type in a currency in requests and
+// responses, nil limits aren't set
+type WalletTypeLimits struct {
+	MinAmount       *money.Decimal `json:"min_amount,omitempty"`
+	MaxAmount       *money.Decimal `json:"max_amount,omitempty"`
+	DailyTopUp      *money.Decimal `json:"daily_top_up,omitempty"`
+	MonthlyTopUp    *money.Decimal `json:"monthly_top_up,omitempty"`
+	DailyOperations *int           `json:"daily_operations,omitempty"`
+	MaxBalance      *money.Decimal `json:"max_balance,omitempty"`
+}
+
+// WalletTypeReq creates the wallet type or changes it. Limits and Lift take
+// effect from EffectiveFrom, the name is changed at once
+type WalletTypeReq struct {
+	ID            int
+	Name          string
+	Currency      string // of the limits
+	Limits        WalletTypeLimits
+	Lift          []string // rules which stop being applied
+	EffectiveFrom time.Time
+	Force         bool // allow a max balance below balances of the type's wallets
+	OperatorID    string
+}
+
+type ScheduledLimitsResp struct {
+	EffectiveFrom time.Time        `json:"effective_from"`
+	Limits        WalletTypeLimits `json:"limits"`
+	Lifted        []string         `json:"lifted,omitempty"`
+}
+
+// CurrencyLimitsResp are limits of a wallet type in the currency
+type CurrencyLimitsResp struct {
+	Currency  string                `json:"currency"`
+	Limits    WalletTypeLimits      `json:"limits"` // limits in force now
+	Scheduled []ScheduledLimitsResp `json:"scheduled"`
+}
+
+// WalletTypeResp is a wallet type with its limits in every currency it has
+// them in. Wallets of the type can be opened only in these currencies
+type WalletTypeResp struct {
+	ID         int                  `json:"id"`
+	Name       string               `json:"name"`
+	Currencies []CurrencyLimitsResp `json:"currencies"`
+}
+
+// WalletLimitsReq overrides limits of the wallet's type for the wallet alone.
+// Limits and Lift take effect from EffectiveFrom
+type WalletLimitsReq struct {
+	WalletID      string
+	Currency      string // of the limits, must be the wallet's currency
+	Limits        WalletTypeLimits
+	Lift          []string // rules which stop being applied to the wallet
+	EffectiveFrom time.Time
+	OperatorID    string
+}
+
+// WalletLimitsResp are the wallet's own limits, which take precedence over
+// the limits of its type
+type WalletLimitsResp struct {
+	WalletID  string                `json:"wallet_id"`
+	Currency  string                `json:"currency"`
+	Limits    WalletTypeLimits      `json:"limits"` // overrides in force now
+	Lifted    []string              `json:"lifted,omitempty"`
+	Scheduled []ScheduledLimitsResp `json:"scheduled"`
+}
+
+type PartnerKeyReq struct {
+	PartnerID  int
+	KeyID      string
+	NotBefore  time.Time
+	NotAfter   *time.Time
+	OperatorID string
+}
+
+type PartnerKeyResp struct {
+	KeyID     string     `json:"key_id"`
+	SecretKey string     `json:"secret_key,omitempty"` // returned only once, when the key is created
+	NotBefore time.Time  `json:"not_before"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PaymentReq is a top-up or a withdrawal of Amount in the wallet's
+// currency. Currency, if set, must be the wallet's one
 type PaymentReq struct {
-	UserID string
-	Amount float64
+	Owner          WalletOwner
+	Amount         money.Decimal
+	Currency       string
+	IdempotencyKey *IdempotencyKey // nil for requests without the header
+}
+
+// TransferReq moves Amount in the sender's currency, which must also be the
+// receiver's one
+type TransferReq struct {
+	From           WalletOwner
+	To             WalletOwner
+	Amount         money.Decimal
+	Currency       string
+	IdempotencyKey *IdempotencyKey
+}
+
+type ReversalReq struct {
+	Owner          WalletOwner
+	TransactionID  int
+	Amount         money.Decimal // empty refunds the rest of the top-up
+	Currency       string
+	IdempotencyKey *IdempotencyKey
+}
+
+type ReversalResp struct {
+	TransactionID int           `json:"transaction_id"`
+	Amount        money.Decimal `json:"amount"`
+	Remaining     money.Decimal `json:"remaining"` // part of the top-up which can still be refunded
+}
+
+type TransferResp struct {
+	TransferID int `json:"transfer_id"`
+}
+
+// RuleViolationResp names the limit rule the operation breaks. Limit is set
+// for amount rules and MaxOperations for the daily operations rule
+type RuleViolationResp struct {
+	Error         string         `json:"error"`
+	Rule          string         `json:"rule"`
+	Limit         *money.Decimal `json:"limit,omitempty"`
+	Currency      string         `json:"currency,omitempty"`
+	MaxOperations *int           `json:"max_operations,omitempty"`
+}
+
+type TransactionsReq struct {
+	Owner     WalletOwner
+	From      time.Time
+	To        time.Time
+	Type      string
+	MinAmount money.Decimal // in the wallet's currency
+	MaxAmount money.Decimal
+	Cursor    string
+	Limit     int
+}
+
+type TransactionResp struct {
+	ID                 int           `json:"id"`
+	Type               string        `json:"type"`
+	Amount             money.Decimal `json:"amount"`
+	Currency           string        `json:"currency"`
+	Counterparty       string        `json:"counterparty,omitempty"`
+	CounterpartyWallet string        `json:"counterparty_wallet_id,omitempty"`
+	Reference          string        `json:"reference,omitempty"`
+	Reversed           money.Decimal `json:"reversed,omitempty"`
+	CreatedAt          time.Time     `json:"created_at"`
+}
+
+type TransactionsResp struct {
+	Transactions []TransactionResp `json:"transactions"`
+	NextCursor   string            `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+type LedgerCheckResp struct {
+	Balanced          bool                     `json:"balanced"`
+	Totals            map[string]money.Decimal `json:"totals"` // by currency
+	UnbalancedEntries []int                    `json:"unbalanced_entries"`
+	AccountMismatches []int                    `json:"account_mismatches"`
+	WalletMismatches  []int                    `json:"wallet_mismatches"`
 }
 
 type WalletResp struct {
-	Balance float64 `json:"balance"`
+	WalletID string        `json:"wallet_id"`
+	Balance  money.Decimal `json:"balance"`
+	Currency string        `json:"currency"`
+	Status   string        `json:"status"`
+}
+
+type WalletStatusReq struct {
+	WalletID          string
+	Status            string
+	Reason            string
+	Comment           string
+	PayoutDestination string // where the balance goes when the wallet is closed
+	OperatorID        string
+}
+
+type WalletStatusResp struct {
+	WalletID            string        `json:"wallet_id"`
+	Status              string        `json:"status"`
+	Reason              string        `json:"reason"`
+	Balance             money.Decimal `json:"balance"`
+	Currency            string        `json:"currency"`
+	PayoutAmount        money.Decimal `json:"payout_amount,omitempty"`
+	PayoutTransactionID int           `json:"payout_transaction_id,omitempty"`
 }
 
 type WalletStatResp struct {
-	Number int     `json:"number"`
-	Amount float64 `json:"amount"`
+	Number   int           `json:"number"`
+	Amount   money.Decimal `json:"amount"`
+	Currency string        `json:"currency"`
+}
+
+type WalletStatsReq struct {
+	Owner   WalletOwner
+	From    time.Time
+	To      time.Time
+	GroupBy string
+}
+
+type WalletStatsBucketResp struct {
+	Start  time.Time     `json:"start"`
+	Number int           `json:"number"`
+	Amount money.Decimal `json:"amount"`
+}
+
+type WalletStatsSeriesResp struct {
+	From     time.Time               `json:"from"`
+	To       time.Time               `json:"to"`
+	GroupBy  string                  `json:"group_by"`
+	Currency string                  `json:"currency"`
+	Buckets  []WalletStatsBucketResp `json:"buckets"`
 }
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// currencyOf returns the currency by its code. Wallets are opened only in
+// known currencies, unknown codes are formatted in minor units
+func currencyOf(code string) money.Currency {
+	currency, err := money.LookupCurrency(code)
+	if err != nil {
+		return money.Currency{Code: code}
+	}
+
+	return currency
+}
+
+// paymentAmount converts the amount of a top-up, a withdrawal or a transfer
+// into minor units of the wallet's currency. The currency of the request, if
+// set, must be the wallet's one, and at least one major unit of it is moved
+func paymentAmount(wallet *models.Wallet, amount money.Decimal, currency string) (money.Amount, error) {
+	if currency != "" && currency != wallet.Currency {
+		return 0, customerrors.ErrCurrencyMismatch
+	}
+
+	walletCurrency := currencyOf(wallet.Currency)
+	minor, err := amount.Amount(walletCurrency)
+	if err != nil {
+		return 0, err
+	}
+
+	if minor < walletCurrency.Unit() {
+		return 0, customerrors.ErrAmountTooSmall
+	}
+
+	return minor, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+)
+
+// GetIdempotentResponse returns the stored response of the request made with
+// the key, or nil when the request should be processed
+func (s *service) GetIdempotentResponse(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	const fn = "service.GetIdempotentResponse"
+
+	existing, err := s.strg.Idempotency().Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	if existing.RequestHash != key.RequestHash {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrIdempotencyKeyReused)
+	}
+
+	return existing, nil
+}
+
+// SaveIdempotentResponse stores the response to the request which was
+// rejected without an operation, so its retries get the same response
+func (s *service) SaveIdempotentResponse(ctx context.Context, key *models.IdempotencyKey) error {
+	const fn = "service.SaveIdempotentResponse"
+
+	key.ExpiresAt = time.Now().Add(s.cfg.IdempotencyTTL)
+	if err := s.strg.Idempotency().SaveRejected(ctx, key); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// reserveKey takes the idempotency key of the request in the transaction of
+// its operation, so the key is kept only if the operation commits. A key
+// whose request is over gives ErrRequestCompleted with the stored response,
+// one still held by a concurrent request gives ErrRequestInProgress
+func (s *service) reserveKey(ctx context.Context, tx *sql.Tx, key *models.IdempotencyKey) error {
+	if key == nil {
+		return nil
+	}
+
+	key.ExpiresAt = time.Now().Add(s.cfg.IdempotencyTTL)
+	existing, err := s.strg.Idempotency().Reserve(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+
+	if existing.RequestHash != key.RequestHash {
+		return customerrors.ErrIdempotencyKeyReused
+	}
+	if existing.StatusCode != 0 {
+		return customerrors.ErrRequestCompleted{StatusCode: existing.StatusCode, Body: existing.ResponseBody}
+	}
+
+	return customerrors.ErrRequestInProgress
+}
+
+// saveResponse stores the response of the operation with its key in the
+// operation's transaction, so a committed operation is replayed rather than
+// run again. The body is encoded the way handlers respond: JSON followed by
+// a newline, or nothing when there's no data
+func (s *service) saveResponse(ctx context.Context, tx *sql.Tx, key *models.IdempotencyKey, resp any) error {
+	if key == nil {
+		return nil
+	}
+
+	key.StatusCode = http.StatusOK
+	key.ResponseBody = nil
+	if resp != nil {
+		body, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		key.ResponseBody = append(body, '\n')
+	}
+
+	return s.strg.Idempotency().Save(ctx, tx, key)
+}
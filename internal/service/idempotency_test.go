@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/parviz-yu/digital-wallet/internal/config"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	"github.com/parviz-yu/digital-wallet/internal/storage"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+)
+
+func TestReserveKey(t *testing.T) {
+	key := func() *models.IdempotencyKey {
+		return &models.IdempotencyKey{Key: "key", PartnerID: 1, UserID: "user", RequestHash: "hash"}
+	}
+	body := []byte(`{"balance":"100"}` + "\n")
+
+	tests := []struct {
+		name     string
+		key      *models.IdempotencyKey
+		existing *models.IdempotencyKey
+		wantErr  error
+		wantResp *customerrors.ErrRequestCompleted
+	}{
+		{
+			name: "request without a key",
+		},
+		{
+			name: "new key",
+			key:  key(),
+		},
+		{
+			name:     "key of another request",
+			key:      key(),
+			existing: &models.IdempotencyKey{RequestHash: "other"},
+			wantErr:  customerrors.ErrIdempotencyKeyReused,
+		},
+		{
+			name:     "key of a request in flight",
+			key:      key(),
+			existing: &models.IdempotencyKey{RequestHash: "hash"},
+			wantErr:  customerrors.ErrRequestInProgress,
+		},
+		{
+			name:     "key of a committed request",
+			key:      key(),
+			existing: &models.IdempotencyKey{RequestHash: "hash", StatusCode: http.StatusOK, ResponseBody: body},
+			wantResp: &customerrors.ErrRequestCompleted{StatusCode: http.StatusOK, Body: body},
+		},
+		{
+			name: "key of a rejected request",
+			key:  key(),
+			existing: &models.IdempotencyKey{
+				RequestHash:  "hash",
+				StatusCode:   http.StatusNotFound,
+				ResponseBody: []byte(`{"error":"wallet not found"}`),
+			},
+			wantResp: &customerrors.ErrRequestCompleted{
+				StatusCode: http.StatusNotFound,
+				Body:       []byte(`{"error":"wallet not found"}`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := fakeStorage{idempotency: &fakeIdempotencyRepo{existing: tt.existing}}
+			svc := NewService(config.Config{}, logger.NewLogger(""), strg).(*service)
+
+			err := svc.reserveKey(context.Background(), nil, tt.key)
+
+			if tt.wantResp != nil {
+				var completed customerrors.ErrRequestCompleted
+				if !errors.As(err, &completed) {
+					t.Fatalf("reserveKey() error = %v, want %v", err, *tt.wantResp)
+				}
+				if completed.StatusCode != tt.wantResp.StatusCode || string(completed.Body) != string(tt.wantResp.Body) {
+					t.Errorf("reserveKey() replays %d %q, want %d %q",
+						completed.StatusCode, completed.Body, tt.wantResp.StatusCode, tt.wantResp.Body)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("reserveKey() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeStorage serves only the repositories a test sets, others panic
+type fakeStorage struct {
+	storage.StorageI
+	idempotency storage.IdempotencyRepoI
+	ledger      storage.LedgerRepoI
+	partner     storage.PartnerRepoI
+}
+
+func (s fakeStorage) Idempotency() storage.IdempotencyRepoI {
+	return s.idempotency
+}
+
+func (s fakeStorage) Ledger() storage.LedgerRepoI {
+	return s.ledger
+}
+
+func (s fakeStorage) Partner() storage.PartnerRepoI {
+	return s.partner
+}
+
+// fakeIdempotencyRepo finds the existing record of every key it reserves
+type fakeIdempotencyRepo struct {
+	storage.IdempotencyRepoI
+	existing *models.IdempotencyKey
+}
+
+func (r *fakeIdempotencyRepo) Reserve(context.Context, *sql.Tx, *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	return r.existing, nil
+}
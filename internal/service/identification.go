@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+)
+
+// SubmitIdentification puts the wallet's identification request on review
+func (s *service) SubmitIdentification(ctx context.Context, req *models.IdentificationReq) (*models.IdentificationResp, error) {
+	const fn = "service.SubmitIdentification"
+
+	identification := &models.Identification{
+		UserID:         req.Owner.UserID,
+		Status:         models.IdentificationPending,
+		FullName:       req.FullName,
+		DocumentType:   req.DocumentType,
+		DocumentNumber: req.DocumentNumber,
+		BirthDate:      req.BirthDate,
+		CreatedAt:      time.Now(),
+	}
+
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		wallet, err := s.strg.Wallet().GetForUpdate(ctx, tx, req.Owner)
+		if err != nil {
+			return err
+		}
+
+		// frozen wallets can still be identified, blocked and closed can't
+		switch wallet.Status {
+		case models.WalletBlocked:
+			return customerrors.ErrWalletBlocked
+		case models.WalletClosed:
+			return customerrors.ErrWalletClosed
+		}
+
+		if wallet.Type == models.WalletTypeIdentified {
+			return customerrors.ErrAlreadyIdentified
+		}
+
+		// the wallet keeps its currency, so the identified type must have
+		// limits in it
+		supported, err := s.strg.Rule().SupportsCurrency(ctx, tx, models.WalletTypeIdentified, wallet.Currency)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			return customerrors.ErrCurrencyNotSupported
+		}
+
+		identification.WalletID = wallet.ID
+		identification.ID, err = s.strg.Identification().Create(ctx, tx, identification)
+		if err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    partnerActor(req.Owner.PartnerID),
+			Action:   models.AuditIdentificationSubmitted,
+			Entity:   "identification_request",
+			EntityID: strconv.Itoa(identification.ID),
+			Details:  map[string]any{"wallet_id": wallet.ID},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return identificationResp(identification), nil
+}
+
+// GetIdentification returns the last identification request of the user's wallet
+func (s *service) GetIdentification(ctx context.Context, owner models.WalletOwner) (*models.IdentificationResp, error) {
+	const fn = "service.GetIdentification"
+
+	walletID, err := s.DoesWalletExists(ctx, owner)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	identification, err := s.strg.Identification().GetLatest(ctx, walletID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return identificationResp(identification), nil
+}
+
+func (s *service) ListIdentifications(ctx context.Context, status string) ([]models.IdentificationResp, error) {
+	const fn = "service.ListIdentifications"
+
+	identifications, err := s.strg.Identification().List(ctx, status)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res := make([]models.IdentificationResp, 0, len(identifications))
+	for i := range identifications {
+		res = append(res, *identificationResp(&identifications[i]))
+	}
+
+	return res, nil
+}
+
+// ReviewIdentification approves or rejects the pending request. On approval
+// the wallet becomes identified, so its new limit applies to the next top-up.
+// A blocked or closed wallet can't be approved, its status is checked under
+// the wallet's lock so it can't change before the type does
+func (s *service) ReviewIdentification(ctx context.Context, review *models.IdentificationReview) (*models.IdentificationResp, error) {
+	const fn = "service.ReviewIdentification"
+
+	var identification *models.Identification
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		identification, err = s.strg.Identification().GetForUpdate(ctx, tx, review.ID)
+		if err != nil {
+			return err
+		}
+
+		if identification.Status != models.IdentificationPending {
+			return customerrors.ErrIdentificationNotPending
+		}
+
+		action := models.AuditIdentificationRejected
+		identification.Status = models.IdentificationRejected
+		identification.RejectReason = review.Reason
+		if review.Approve {
+			action = models.AuditIdentificationApproved
+			identification.Status = models.IdentificationApproved
+			identification.RejectReason = ""
+
+			wallet, err := s.strg.Wallet().GetByIDForUpdate(ctx, tx, identification.WalletID)
+			if err != nil {
+				return err
+			}
+			switch wallet.Status {
+			case models.WalletBlocked:
+				return customerrors.ErrWalletBlocked
+			case models.WalletClosed:
+				return customerrors.ErrWalletClosed
+			}
+
+			if err := s.strg.Wallet().UpdateType(ctx, tx, wallet.ID, models.WalletTypeIdentified); err != nil {
+				return err
+			}
+		}
+		identification.ReviewedBy = review.OperatorID
+
+		if err := s.strg.Identification().Review(ctx, tx, identification); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    review.OperatorID,
+			Action:   action,
+			Entity:   "identification_request",
+			EntityID: strconv.Itoa(identification.ID),
+			Details:  map[string]any{"wallet_id": identification.WalletID, "reason": review.Reason},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return identificationResp(identification), nil
+}
+
+func identificationResp(identification *models.Identification) *models.IdentificationResp {
+	return &models.IdentificationResp{
+		ID:             identification.ID,
+		UserID:         identification.UserID,
+		Status:         identification.Status,
+		FullName:       identification.FullName,
+		DocumentType:   identification.DocumentType,
+		DocumentNumber: identification.DocumentNumber,
+		BirthDate:      identification.BirthDate.Format(models.DateLayout),
+		RejectReason:   identification.RejectReason,
+		CreatedAt:      identification.CreatedAt,
+	}
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// CheckLedger verifies that the ledger of every currency sums to zero and
+// balances cached in accounts and wallets match the postings
+func (s *service) CheckLedger(ctx context.Context) (*models.LedgerCheckResp, error) {
+	const fn = "service.CheckLedger"
+
+	check, err := s.strg.Ledger().Check(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res := &models.LedgerCheckResp{
+		Balanced: len(check.UnbalancedEntries) == 0 &&
+			len(check.AccountMismatches) == 0 &&
+			len(check.WalletMismatches) == 0,
+		Totals:            make(map[string]money.Decimal, len(check.Totals)),
+		UnbalancedEntries: check.UnbalancedEntries,
+		AccountMismatches: check.AccountMismatches,
+		WalletMismatches:  check.WalletMismatches,
+	}
+	for currency, total := range check.Totals {
+		res.Totals[currency] = currencyOf(currency).Format(total)
+		if total != 0 {
+			res.Balanced = false
+		}
+	}
+
+	return res, nil
+}
+
+// postPayment records a top-up, a withdrawal or a reversal of the wallet in
+// the ledger. Top-ups move money from the partner's settlement account to
+// the wallet, withdrawals and reversals move it back. The partner pays the
+// fee of top-ups and withdrawals into the fee account. Without a settlement
+// account the money is parked in the suspense account instead of failing
+// the operation
+func (s *service) postPayment(ctx context.Context, tx *sql.Tx, kind string, transactionID int, wallet *models.Wallet, amount money.Amount) error {
+	walletAccount, err := s.strg.Ledger().GetWalletAccount(ctx, tx, wallet.ID)
+	if err != nil {
+		return err
+	}
+
+	var fee money.Amount
+	partnerAccount, err := s.strg.Ledger().GetSettlementAccount(ctx, tx, wallet.PartnerID, wallet.Currency)
+	switch {
+	case errors.Is(err, customerrors.ErrAccountNotFound):
+		s.log.Warn("no settlement account, posting to suspense",
+			logger.Int("partner_id", wallet.PartnerID),
+			logger.String("currency", wallet.Currency),
+			logger.Int("transaction_id", transactionID),
+		)
+
+		partnerAccount, err = s.strg.Ledger().GetSystemAccount(ctx, tx, models.AccountSuspense, wallet.Currency)
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case kind == models.EntryTopUp || kind == models.EntryWithdrawal:
+		if fee, err = s.partnerFee(ctx, wallet.PartnerID, amount); err != nil {
+			return err
+		}
+	}
+
+	// the partner pays the fee on top of a top-up and gets it held back from
+	// a withdrawal
+	postings := []models.Posting{
+		{AccountID: partnerAccount, Amount: -amount - fee},
+		{AccountID: walletAccount.ID, Amount: amount},
+	}
+	if kind != models.EntryTopUp {
+		postings = []models.Posting{
+			{AccountID: walletAccount.ID, Amount: -amount},
+			{AccountID: partnerAccount, Amount: amount - fee},
+		}
+	}
+	if fee > 0 {
+		feeAccount, err := s.strg.Ledger().GetSystemAccount(ctx, tx, models.AccountFee, wallet.Currency)
+		if err != nil {
+			return err
+		}
+		postings = append(postings, models.Posting{AccountID: feeAccount, Amount: fee})
+	}
+
+	_, err = s.strg.Ledger().Post(ctx, tx, &models.JournalEntry{
+		Kind:      kind,
+		Reference: transactionRef(transactionID),
+		Postings:  postings,
+	})
+	return err
+}
+
+// partnerFee returns the partner's fee of the operation, rounded down to
+// minor units of the currency
+func (s *service) partnerFee(ctx context.Context, partnerID int, amount money.Amount) (money.Amount, error) {
+	partner, err := s.strg.Partner().GetPartner(ctx, partnerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return amount * money.Amount(partner.FeeBPS) / 10000, nil
+}
+
+// postTransfer records a transfer between the wallets in the ledger
+func (s *service) postTransfer(ctx context.Context, tx *sql.Tx, transferID int, sender, receiver *models.Wallet, amount money.Amount) error {
+	from, err := s.strg.Ledger().GetWalletAccount(ctx, tx, sender.ID)
+	if err != nil {
+		return err
+	}
+
+	to, err := s.strg.Ledger().GetWalletAccount(ctx, tx, receiver.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.post(ctx, tx, models.EntryTransfer, transferRef(transferID), from.ID, to.ID, amount)
+}
+
+// postDrift moves the difference between the wallet's balance and its
+// ledger account to the suspense account, so the ledger accounts for the
+// money the wallet holds until the difference is investigated. It returns
+// the id of the entry, zero if the ledger already matches the wallet
+func (s *service) postDrift(ctx context.Context, walletID int) (int, error) {
+	var entryID int
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		wallet, err := s.strg.Wallet().GetByIDForUpdate(ctx, tx, walletID)
+		if err != nil {
+			return err
+		}
+
+		account, err := s.strg.Ledger().GetWalletAccount(ctx, tx, wallet.ID)
+		if err != nil {
+			return err
+		}
+
+		diff := wallet.Balance - account.Balance
+		if diff == 0 {
+			return nil
+		}
+
+		suspense, err := s.strg.Ledger().GetSystemAccount(ctx, tx, models.AccountSuspense, wallet.Currency)
+		if err != nil {
+			return err
+		}
+
+		entryID, err = s.strg.Ledger().Post(ctx, tx, &models.JournalEntry{
+			Kind:      models.EntryReconciliation,
+			Reference: walletRef(wallet.ID),
+			Postings: []models.Posting{
+				{AccountID: suspense, Amount: -diff},
+				{AccountID: account.ID, Amount: diff},
+			},
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return entryID, nil
+}
+
+// post writes the journal entry moving the amount from one account to another
+func (s *service) post(ctx context.Context, tx *sql.Tx, kind, reference string, from, to int, amount money.Amount) error {
+	entry := &models.JournalEntry{
+		Kind:      kind,
+		Reference: reference,
+		Postings: []models.Posting{
+			{AccountID: from, Amount: -amount},
+			{AccountID: to, Amount: amount},
+		},
+	}
+
+	_, err := s.strg.Ledger().Post(ctx, tx, entry)
+	return err
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/parviz-yu/digital-wallet/internal/config"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	"github.com/parviz-yu/digital-wallet/internal/storage"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// account ids of fakeLedgerRepo
+const (
+	walletAccount     = 1
+	settlementAccount = 2
+	feeAccount        = 3
+	suspenseAccount   = 4
+)
+
+func TestPostPayment(t *testing.T) {
+	tests := []struct {
+		name         string
+		kind         string
+		amount       money.Amount
+		feeBPS       int
+		noSettlement bool
+		wantPostings []models.Posting
+	}{
+		{
+			name:   "top-up without fee",
+			kind:   models.EntryTopUp,
+			amount: 10000,
+			wantPostings: []models.Posting{
+				{AccountID: settlementAccount, Amount: -10000},
+				{AccountID: walletAccount, Amount: 10000},
+			},
+		},
+		{
+			name:   "top-up with fee",
+			kind:   models.EntryTopUp,
+			amount: 10000,
+			feeBPS: 50,
+			wantPostings: []models.Posting{
+				{AccountID: settlementAccount, Amount: -10050},
+				{AccountID: walletAccount, Amount: 10000},
+				{AccountID: feeAccount, Amount: 50},
+			},
+		},
+		{
+			name:   "withdrawal with fee",
+			kind:   models.EntryWithdrawal,
+			amount: 10000,
+			feeBPS: 50,
+			wantPostings: []models.Posting{
+				{AccountID: walletAccount, Amount: -10000},
+				{AccountID: settlementAccount, Amount: 9950},
+				{AccountID: feeAccount, Amount: 50},
+			},
+		},
+		{
+			name:   "fee below a minor unit",
+			kind:   models.EntryTopUp,
+			amount: 199,
+			feeBPS: 50,
+			wantPostings: []models.Posting{
+				{AccountID: settlementAccount, Amount: -199},
+				{AccountID: walletAccount, Amount: 199},
+			},
+		},
+		{
+			name:   "reversal without fee",
+			kind:   models.EntryReversal,
+			amount: 10000,
+			feeBPS: 50,
+			wantPostings: []models.Posting{
+				{AccountID: walletAccount, Amount: -10000},
+				{AccountID: settlementAccount, Amount: 10000},
+			},
+		},
+		{
+			name:         "no settlement account",
+			kind:         models.EntryTopUp,
+			amount:       10000,
+			feeBPS:       50,
+			noSettlement: true,
+			wantPostings: []models.Posting{
+				{AccountID: suspenseAccount, Amount: -10000},
+				{AccountID: walletAccount, Amount: 10000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := &fakeLedgerRepo{noSettlement: tt.noSettlement}
+			strg := fakeStorage{
+				ledger:  ledger,
+				partner: &fakePartnerRepo{partner: &models.Partner{ID: 1, FeeBPS: tt.feeBPS}},
+			}
+			svc := NewService(config.Config{}, logger.NewLogger(""), strg).(*service)
+
+			wallet := &models.Wallet{ID: 1, PartnerID: 1, Currency: money.TJS}
+			if err := svc.postPayment(context.Background(), nil, tt.kind, 1, wallet, tt.amount); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ledger.entries) != 1 {
+				t.Fatalf("postPayment() posted %d entries, want 1", len(ledger.entries))
+			}
+			if got := ledger.entries[0].Postings; !reflect.DeepEqual(got, tt.wantPostings) {
+				t.Errorf("postPayment() postings = %v, want %v", got, tt.wantPostings)
+			}
+		})
+	}
+}
+
+// fakeLedgerRepo has an account of every type and keeps posted entries
+type fakeLedgerRepo struct {
+	storage.LedgerRepoI
+	noSettlement bool
+	entries      []*models.JournalEntry
+}
+
+func (r *fakeLedgerRepo) GetWalletAccount(context.Context, *sql.Tx, int) (*models.Account, error) {
+	return &models.Account{ID: walletAccount}, nil
+}
+
+func (r *fakeLedgerRepo) GetSettlementAccount(context.Context, *sql.Tx, int, string) (int, error) {
+	if r.noSettlement {
+		return 0, customerrors.ErrAccountNotFound
+	}
+	return settlementAccount, nil
+}
+
+func (r *fakeLedgerRepo) GetSystemAccount(_ context.Context, _ *sql.Tx, accountType, _ string) (int, error) {
+	if accountType == models.AccountFee {
+		return feeAccount, nil
+	}
+	return suspenseAccount, nil
+}
+
+func (r *fakeLedgerRepo) Post(_ context.Context, _ *sql.Tx, entry *models.JournalEntry) (int, error) {
+	r.entries = append(r.entries, entry)
+	return len(r.entries), nil
+}
+
+type fakePartnerRepo struct {
+	storage.PartnerRepoI
+	partner *models.Partner
+}
+
+func (r *fakePartnerRepo) GetPartner(context.Context, int) (*models.Partner, error) {
+	return r.partner, nil
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+	"github.com/parviz-yu/digital-wallet/pkg/security"
+)
+
+// GetPartner returns the partner together with the keys valid right now
+func (s *service) GetPartner(ctx context.Context, id int) (*models.Partner, error) {
+	const fn = "service.GetPartner"
+
+	partner, err := s.strg.Partner().GetPartner(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	partner.Keys, err = s.strg.Partner().GetActiveKeys(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return partner, nil
+}
+
+// CheckReplay accepts a signed request only once and only within the allowed
+// clock skew. The nonce is remembered for as long as the timestamp is valid
+func (s *service) CheckReplay(ctx context.Context, partnerID int, nonce string, signedAt time.Time) error {
+	const fn = "service.CheckReplay"
+
+	skew := time.Since(signedAt)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > s.cfg.SignatureMaxSkew {
+		return fmt.Errorf("%s: %w", fn, customerrors.ErrStaleRequest)
+	}
+
+	expiresAt := signedAt.Add(s.cfg.SignatureMaxSkew)
+	if err := s.strg.Nonce().Use(ctx, partnerID, nonce, expiresAt); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// PurgeNonces forgets nonces whose requests can't be replayed anymore, as
+// their timestamps are out of the allowed clock skew
+func (s *service) PurgeNonces(ctx context.Context) error {
+	const fn = "service.PurgeNonces"
+
+	purged, err := s.strg.Nonce().Purge(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	s.log.Debug("expired nonces purged", logger.Int("purged", int(purged)))
+
+	return nil
+}
+
+func (s *service) ListPartnerKeys(ctx context.Context, partnerID int) ([]models.PartnerKeyResp, error) {
+	const fn = "service.ListPartnerKeys"
+
+	if _, err := s.strg.Partner().GetPartner(ctx, partnerID); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	keys, err := s.strg.Partner().ListKeys(ctx, partnerID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res := make([]models.PartnerKeyResp, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, models.PartnerKeyResp{
+			KeyID:     key.KeyID,
+			NotBefore: key.NotBefore,
+			NotAfter:  key.NotAfter,
+			RevokedAt: key.RevokedAt,
+			CreatedAt: key.CreatedAt,
+		})
+	}
+
+	return res, nil
+}
+
+// CreatePartnerKey generates a new secret key for the partner. The secret is
+// returned only here and has to be handed over to the partner
+func (s *service) CreatePartnerKey(ctx context.Context, req *models.PartnerKeyReq) (*models.PartnerKeyResp, error) {
+	const fn = "service.CreatePartnerKey"
+
+	if _, err := s.strg.Partner().GetPartner(ctx, req.PartnerID); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	secret, err := security.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	key := &models.PartnerKey{
+		PartnerID: req.PartnerID,
+		KeyID:     req.KeyID,
+		SecretKey: secret,
+		NotBefore: req.NotBefore,
+		NotAfter:  req.NotAfter,
+	}
+
+	err = s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		if _, err := s.strg.Partner().CreateKey(ctx, tx, key); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    req.OperatorID,
+			Action:   models.AuditPartnerKeyCreated,
+			Entity:   "partner",
+			EntityID: strconv.Itoa(req.PartnerID),
+			Details:  map[string]any{"key_id": req.KeyID, "not_before": req.NotBefore, "not_after": req.NotAfter},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res := &models.PartnerKeyResp{
+		KeyID:     key.KeyID,
+		SecretKey: key.SecretKey,
+		NotBefore: key.NotBefore,
+		NotAfter:  key.NotAfter,
+		CreatedAt: time.Now(),
+	}
+
+	return res, nil
+}
+
+func (s *service) RevokePartnerKey(ctx context.Context, partnerID int, keyID, operatorID string) error {
+	const fn = "service.RevokePartnerKey"
+
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		if err := s.strg.Partner().RevokeKey(ctx, tx, partnerID, keyID); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    operatorID,
+			Action:   models.AuditPartnerKeyRevoked,
+			Entity:   "partner",
+			EntityID: strconv.Itoa(partnerID),
+			Details:  map[string]any{"key_id": keyID},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// Reconcile compares stored wallet balances with the transaction log, moves
+// the difference of every drifted wallet with the ledger to the suspense
+// account, saves the report and logs the drifted wallets
+func (s *service) Reconcile(ctx context.Context) (*models.ReconciliationRun, error) {
+	const fn = "service.Reconcile"
+
+	log := logger.With(s.log, logger.String("fn", fn))
+
+	checked, drifts, err := s.strg.Reconciliation().FindDrifts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	for i := range drifts {
+		drifts[i].EntryID, err = s.postDrift(ctx, drifts[i].WalletID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+
+	run := &models.ReconciliationRun{
+		WalletsChecked: checked,
+		Drifts:         drifts,
+	}
+	run.ID, err = s.strg.Reconciliation().SaveRun(ctx, run)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	for _, drift := range drifts {
+		log.Warn("wallet balance drifted",
+			logger.Int("run_id", run.ID),
+			logger.Int("wallet_id", drift.WalletID),
+			logger.String("balance", money.Money{Amount: drift.Balance, Currency: drift.Currency}.String()),
+			logger.String("expected", money.Money{Amount: drift.Expected, Currency: drift.Currency}.String()),
+			logger.Int("suspense_entry_id", drift.EntryID),
+		)
+	}
+	log.Info("balances reconciled",
+		logger.Int("run_id", run.ID),
+		logger.Int("wallets_checked", checked),
+		logger.Int("drifts", len(drifts)),
+	)
+
+	return run, nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// Reverse refunds the top-up of the wallet, fully or in part. The refunded
+// money goes back to the partner's settlement account
+func (s *service) Reverse(ctx context.Context, reversal *models.ReversalReq) (*models.ReversalResp, error) {
+	const fn = "service.Reverse"
+
+	res := &models.ReversalResp{}
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		if err := s.reserveKey(ctx, tx, reversal.IdempotencyKey); err != nil {
+			return err
+		}
+
+		wallet, err := s.strg.Wallet().GetForUpdate(ctx, tx, reversal.Owner)
+		if err != nil {
+			return err
+		}
+
+		if err := checkStatus(wallet, false); err != nil {
+			return err
+		}
+
+		if reversal.Currency != "" && reversal.Currency != wallet.Currency {
+			return customerrors.ErrCurrencyMismatch
+		}
+
+		original, err := s.strg.Transaction().GetForUpdate(ctx, tx, reversal.TransactionID)
+		if err != nil {
+			return err
+		}
+
+		// transactions of other wallets are hidden from the partner
+		if original.WalletID != wallet.ID {
+			return customerrors.ErrTransactionNotFound
+		}
+		if original.Type != models.TxTypeTopUp {
+			return customerrors.ErrNotReversible
+		}
+
+		remaining := original.Amount - original.Reversed
+		if remaining == 0 {
+			return customerrors.ErrAlreadyReversed
+		}
+
+		amount := remaining
+		if reversal.Amount != "" {
+			amount, err = reversal.Amount.Amount(currencyOf(wallet.Currency))
+			if err != nil {
+				return err
+			}
+		}
+		if amount > remaining {
+			return customerrors.ErrReversalTooLarge
+		}
+		// limit rules aren't checked, the refund only returns money which
+		// came in under them
+		if amount > wallet.Balance {
+			return customerrors.ErrInsufficientFunds{Balance: wallet.Balance, Amount: amount, Currency: wallet.Currency}
+		}
+
+		res.TransactionID, err = s.strg.Transaction().Reverse(ctx, tx, &models.Reversal{
+			WalletID:   wallet.ID,
+			OriginalID: original.ID,
+			Amount:     amount,
+		})
+		if err != nil {
+			return err
+		}
+		res.Amount = currencyOf(wallet.Currency).Format(amount)
+		res.Remaining = currencyOf(wallet.Currency).Format(remaining - amount)
+
+		pay := &models.Payment{Amount: amount, WalletID: wallet.ID}
+		if err := s.strg.Wallet().DecreaseBalance(ctx, tx, pay); err != nil {
+			return err
+		}
+
+		if err := s.postPayment(ctx, tx, models.EntryReversal, res.TransactionID, wallet, amount); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    partnerActor(reversal.Owner.PartnerID),
+			Action:   models.AuditTransactionReversed,
+			Entity:   "transaction",
+			EntityID: strconv.Itoa(original.ID),
+			Details:  map[string]any{"reversal_id": res.TransactionID, "amount": money.Money{Amount: amount, Currency: wallet.Currency}.String()},
+		}
+		if err := s.strg.Audit().Add(ctx, tx, record); err != nil {
+			return err
+		}
+
+		return s.saveResponse(ctx, tx, reversal.IdempotencyKey, res)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// checkRules evaluates the limit rules of the locked wallet before its
+// balance is changed by the operation of txType. Turnover is read only when
+// the wallet has rules which need it
+func (s *service) checkRules(ctx context.Context, tx *sql.Tx, wallet *models.Wallet, txType string, amount money.Amount) error {
+	rules, err := s.strg.Rule().GetWalletRules(ctx, tx, wallet)
+	if err != nil {
+		return err
+	}
+
+	incoming := txType == models.TxTypeTopUp || txType == models.TxTypeTransferIn
+	// incoming transfers aren't made by the wallet, so they don't count
+	// as its operations
+	initiated := txType != models.TxTypeTransferIn
+
+	var turnover *models.Turnover
+	getTurnover := func() (*models.Turnover, error) {
+		if turnover != nil {
+			return turnover, nil
+		}
+
+		now := time.Now()
+		location := s.cfg.BusinessLocation()
+		monthStart, _ := monthRange(now, location)
+		turnover, err = s.strg.Transaction().GetTurnover(ctx, tx, wallet.ID, dayStart(now, location), monthStart)
+		return turnover, err
+	}
+
+	for _, rule := range rules {
+		limit := money.Amount(rule.Value)
+
+		var violated bool
+		switch rule.Rule {
+		case models.RuleMinAmount:
+			violated = amount < limit
+		case models.RuleMaxAmount:
+			violated = amount > limit
+		case models.RuleMaxBalance:
+			violated = incoming && wallet.Balance+amount > limit
+		case models.RuleDailyTopUp, models.RuleMonthlyTopUp:
+			if !incoming {
+				continue
+			}
+			turnover, err := getTurnover()
+			if err != nil {
+				return err
+			}
+			spent := turnover.DailyIncoming
+			if rule.Rule == models.RuleMonthlyTopUp {
+				spent = turnover.MonthlyIncoming
+			}
+			violated = spent+amount > limit
+		case models.RuleDailyOperations:
+			if !initiated {
+				continue
+			}
+			turnover, err := getTurnover()
+			if err != nil {
+				return err
+			}
+			violated = int64(turnover.DailyOperations) >= rule.Value
+		}
+
+		if violated {
+			return customerrors.ErrRuleViolated{
+				Rule:       rule.Rule,
+				Limit:      rule.Value,
+				Currency:   wallet.Currency,
+				Operations: rule.Rule == models.RuleDailyOperations,
+			}
+		}
+	}
+
+	return nil
+}
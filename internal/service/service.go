@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/parviz-yu/digital-wallet/internal/config"
@@ -13,12 +15,50 @@ import (
 )
 
 type ServiceI interface {
-	DoesWalletExists(ctx context.Context, userID string) (int, error)
+	GetPartner(ctx context.Context, id int) (*models.Partner, error)
+	DoesWalletExists(ctx context.Context, owner models.WalletOwner) (int, error)
+	CreateWallet(ctx context.Context, wallet *models.CreateWalletReq) (*models.CreateWalletResp, error)
 	PutFunds(ctx context.Context, payment *models.PaymentReq) error
-	GetWalletStats(ctx context.Context, userID string) (*models.WalletStatResp, error)
-	GetWalletBalance(ctx context.Context, userID string) (*models.WalletResp, error)
+	Withdraw(ctx context.Context, payment *models.PaymentReq) error
+	Transfer(ctx context.Context, transfer *models.TransferReq) (*models.TransferResp, error)
+	Reverse(ctx context.Context, reversal *models.ReversalReq) (*models.ReversalResp, error)
+
+	SubmitIdentification(ctx context.Context, req *models.IdentificationReq) (*models.IdentificationResp, error)
+	GetIdentification(ctx context.Context, owner models.WalletOwner) (*models.IdentificationResp, error)
+	ListIdentifications(ctx context.Context, status string) ([]models.IdentificationResp, error)
+	ReviewIdentification(ctx context.Context, review *models.IdentificationReview) (*models.IdentificationResp, error)
+
+	CheckReplay(ctx context.Context, partnerID int, nonce string, signedAt time.Time) error
+	PurgeNonces(ctx context.Context) error
+	ListPartnerKeys(ctx context.Context, partnerID int) ([]models.PartnerKeyResp, error)
+	CreatePartnerKey(ctx context.Context, req *models.PartnerKeyReq) (*models.PartnerKeyResp, error)
+	RevokePartnerKey(ctx context.Context, partnerID int, keyID, operatorID string) error
+
+	GetIdempotentResponse(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	SaveIdempotentResponse(ctx context.Context, key *models.IdempotencyKey) error
+	GetWalletStats(ctx context.Context, owner models.WalletOwner) (*models.WalletStatResp, error)
+	GetWalletStatsSeries(ctx context.Context, req *models.WalletStatsReq) (*models.WalletStatsSeriesResp, error)
+	GetWalletBalance(ctx context.Context, owner models.WalletOwner) (*models.WalletResp, error)
+	ListWallets(ctx context.Context, owner models.WalletOwner) ([]models.WalletItemResp, error)
+	SetDefaultWallet(ctx context.Context, owner models.WalletOwner) error
+	ListTransactions(ctx context.Context, req *models.TransactionsReq) (*models.TransactionsResp, error)
+
+	ListWalletTypes(ctx context.Context) ([]models.WalletTypeResp, error)
+	CreateWalletType(ctx context.Context, req *models.WalletTypeReq) (*models.WalletTypeResp, error)
+	UpdateWalletType(ctx context.Context, req *models.WalletTypeReq) (*models.WalletTypeResp, error)
+	GetWalletLimits(ctx context.Context, walletID string) (*models.WalletLimitsResp, error)
+	UpdateWalletLimits(ctx context.Context, req *models.WalletLimitsReq) (*models.WalletLimitsResp, error)
+	DeleteWalletLimit(ctx context.Context, walletID, rule, operatorID string) error
+
+	ChangeWalletStatus(ctx context.Context, req *models.WalletStatusReq) (*models.WalletStatusResp, error)
+
+	CheckLedger(ctx context.Context) (*models.LedgerCheckResp, error)
+	Reconcile(ctx context.Context) (*models.ReconciliationRun, error)
 }
 
+// maxStatsPeriod bounds the number of buckets of the stats series
+const maxStatsPeriod = 366 * 24 * time.Hour
+
 type service struct {
 	cfg  config.Config
 	log  logger.LoggerI
@@ -33,10 +73,10 @@ func NewService(cfg config.Config, log logger.LoggerI, strg storage.StorageI) Se
 	}
 }
 
-func (s *service) DoesWalletExists(ctx context.Context, userID string) (int, error) {
+func (s *service) DoesWalletExists(ctx context.Context, owner models.WalletOwner) (int, error) {
 	const fn = "service.DoesWalletExists"
 
-	walletID, err := s.strg.Wallet().GetWallet(ctx, userID)
+	walletID, err := s.strg.Wallet().GetWallet(ctx, owner)
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", fn, err)
 	}
@@ -44,34 +84,152 @@ func (s *service) DoesWalletExists(ctx context.Context, userID string) (int, err
 	return walletID, nil
 }
 
-func (s *service) GetWalletBalance(ctx context.Context, userID string) (*models.WalletResp, error) {
+func (s *service) CreateWallet(ctx context.Context, wallet *models.CreateWalletReq) (*models.CreateWalletResp, error) {
+	const fn = "service.CreateWallet"
+
+	walletType, err := s.strg.WalletType().Get(ctx, wallet.Type)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	wllt := &models.Wallet{
+		PartnerID: wallet.Owner.PartnerID,
+		UserID:    wallet.Owner.UserID,
+		Name:      wallet.Name,
+		Type:      wallet.Type,
+		Currency:  wallet.Currency,
+	}
+	err = s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		// without limits in the currency the wallet's balance couldn't be capped
+		supported, err := s.strg.Rule().SupportsCurrency(ctx, tx, wllt.Type, wllt.Currency)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			return customerrors.ErrCurrencyNotSupported
+		}
+
+		walletID, err := s.strg.Wallet().CreateWallet(ctx, tx, wllt)
+		if err != nil {
+			return err
+		}
+
+		wllt.ID = walletID
+		if _, err := s.strg.Ledger().CreateWalletAccount(ctx, tx, wllt); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    partnerActor(wallet.Owner.PartnerID),
+			Action:   models.AuditWalletCreated,
+			Entity:   "wallet",
+			EntityID: strconv.Itoa(walletID),
+			Details: map[string]any{
+				"user_id":    wallet.Owner.UserID,
+				"wallet_id":  wllt.PublicID,
+				"type":       wallet.Type,
+				"currency":   wllt.Currency,
+				"is_default": wllt.IsDefault,
+			},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res := &models.CreateWalletResp{
+		WalletID: wllt.PublicID,
+		Name:     wllt.Name,
+		Default:  wllt.IsDefault,
+		Type:     walletType.Name,
+		Balance:  currencyOf(wllt.Currency).Format(0),
+		Currency: wllt.Currency,
+	}
+
+	return res, nil
+}
+
+func (s *service) GetWalletBalance(ctx context.Context, owner models.WalletOwner) (*models.WalletResp, error) {
 	const fn = "service.GetWalletBalance"
 
-	wllt, err := s.strg.Wallet().CheckBalance(ctx, userID)
+	wllt, err := s.strg.Wallet().CheckBalance(ctx, owner)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", fn, err)
 	}
 
 	res := &models.WalletResp{
-		Balance: float64(wllt.Balance) / 100,
+		WalletID: wllt.PublicID,
+		Balance:  currencyOf(wllt.Currency).Format(wllt.Balance),
+		Currency: wllt.Currency,
+		Status:   wllt.Status,
+	}
+
+	return res, nil
+}
+
+// ListWallets returns all wallets of the owner's user, the default one first
+func (s *service) ListWallets(ctx context.Context, owner models.WalletOwner) ([]models.WalletItemResp, error) {
+	const fn = "service.ListWallets"
+
+	wallets, err := s.strg.Wallet().List(ctx, owner.PartnerID, owner.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res := make([]models.WalletItemResp, 0, len(wallets))
+	for _, wallet := range wallets {
+		res = append(res, models.WalletItemResp{
+			WalletID: wallet.PublicID,
+			Name:     wallet.Name,
+			Default:  wallet.IsDefault,
+			Type:     wallet.TypeName,
+			Balance:  currencyOf(wallet.Currency).Format(wallet.Balance),
+			Currency: wallet.Currency,
+			Status:   wallet.Status,
+		})
 	}
 
 	return res, nil
 }
 
-func (s *service) GetWalletStats(ctx context.Context, userID string) (*models.WalletStatResp, error) {
+// SetDefaultWallet makes the wallet the one used by routes without a wallet
+// id. Closed wallets can't be default
+func (s *service) SetDefaultWallet(ctx context.Context, owner models.WalletOwner) error {
+	const fn = "service.SetDefaultWallet"
+
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		wallet, err := s.strg.Wallet().GetForUpdate(ctx, tx, owner)
+		if err != nil {
+			return err
+		}
+
+		if wallet.Status == models.WalletClosed {
+			return customerrors.ErrWalletClosed
+		}
+
+		return s.strg.Wallet().SetDefault(ctx, tx, wallet)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+func (s *service) GetWalletStats(ctx context.Context, owner models.WalletOwner) (*models.WalletStatResp, error) {
 	const fn = "service.GetWalletStats"
 
-	walledID, err := s.DoesWalletExists(ctx, userID)
+	wallet, err := s.strg.Wallet().CheckBalance(ctx, owner)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", fn, err)
 	}
 
-	today := time.Now()
+	begin, end := monthRange(time.Now(), s.cfg.BusinessLocation())
 	statRange := &models.WalletStatsRange{
-		WalletID:  walledID,
-		DateBegin: monthStart(today),
-		DateEnd:   monthEnd(today),
+		WalletID:  wallet.ID,
+		DateBegin: begin,
+		DateEnd:   end,
 	}
 
 	monthlyStats, err := s.strg.Transaction().GetMonthlyStats(ctx, statRange)
@@ -80,8 +238,67 @@ func (s *service) GetWalletStats(ctx context.Context, userID string) (*models.Wa
 	}
 
 	res := &models.WalletStatResp{
-		Number: monthlyStats.Number,
-		Amount: float64(monthlyStats.Amount) / 100,
+		Number:   monthlyStats.Number,
+		Amount:   currencyOf(wallet.Currency).Format(monthlyStats.Amount),
+		Currency: wallet.Currency,
+	}
+
+	return res, nil
+}
+
+// GetWalletStatsSeries returns refills' stats of the range split into
+// buckets. The range defaults to the current month and buckets to months
+func (s *service) GetWalletStatsSeries(ctx context.Context, req *models.WalletStatsReq) (*models.WalletStatsSeriesResp, error) {
+	const fn = "service.GetWalletStatsSeries"
+
+	wallet, err := s.strg.Wallet().CheckBalance(ctx, req.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	location := s.cfg.BusinessLocation()
+	begin, end := monthRange(time.Now(), location)
+	statRange := &models.WalletStatsRange{
+		WalletID:  wallet.ID,
+		DateBegin: req.From,
+		DateEnd:   req.To,
+		GroupBy:   req.GroupBy,
+		Location:  location,
+	}
+	if statRange.DateBegin.IsZero() {
+		statRange.DateBegin = begin
+	}
+	if statRange.DateEnd.IsZero() {
+		statRange.DateEnd = end
+	}
+	if statRange.GroupBy == "" {
+		statRange.GroupBy = models.StatsByMonth
+	}
+
+	period := statRange.DateEnd.Sub(statRange.DateBegin)
+	if period <= 0 || period > maxStatsPeriod {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrInvalidStatsPeriod)
+	}
+
+	buckets, err := s.strg.Transaction().GetStatsSeries(ctx, statRange)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res := &models.WalletStatsSeriesResp{
+		From:     statRange.DateBegin.In(location),
+		To:       statRange.DateEnd.In(location),
+		GroupBy:  statRange.GroupBy,
+		Currency: wallet.Currency,
+		Buckets:  make([]models.WalletStatsBucketResp, 0, len(buckets)),
+	}
+	currency := currencyOf(wallet.Currency)
+	for _, bucket := range buckets {
+		res.Buckets = append(res.Buckets, models.WalletStatsBucketResp{
+			Start:  bucket.Start.In(location),
+			Number: bucket.Number,
+			Amount: currency.Format(bucket.Amount),
+		})
 	}
 
 	return res, nil
@@ -90,64 +307,248 @@ func (s *service) GetWalletStats(ctx context.Context, userID string) (*models.Wa
 func (s *service) PutFunds(ctx context.Context, payment *models.PaymentReq) error {
 	const fn = "service.PutFunds"
 
-	wallet, err := s.strg.Wallet().CheckBalance(ctx, payment.UserID)
+	// limits are checked against the locked row, so concurrent top-ups
+	// can't push the balance or the turnover above them
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		if err := s.reserveKey(ctx, tx, payment.IdempotencyKey); err != nil {
+			return err
+		}
+
+		wallet, err := s.strg.Wallet().GetForUpdate(ctx, tx, payment.Owner)
+		if err != nil {
+			return err
+		}
+
+		if err := checkStatus(wallet, true); err != nil {
+			return err
+		}
+
+		amount, err := paymentAmount(wallet, payment.Amount, payment.Currency)
+		if err != nil {
+			return err
+		}
+
+		if err := s.checkRules(ctx, tx, wallet, models.TxTypeTopUp, amount); err != nil {
+			return err
+		}
+
+		pay := &models.Payment{
+			Amount:   amount,
+			WalletID: wallet.ID,
+		}
+		transactionID, err := s.strg.Transaction().PutFunds(ctx, tx, pay)
+		if err != nil {
+			return err
+		}
+
+		if err := s.strg.Wallet().UpdateBalance(ctx, tx, pay); err != nil {
+			return err
+		}
+
+		if err := s.postPayment(ctx, tx, models.EntryTopUp, transactionID, wallet, amount); err != nil {
+			return err
+		}
+
+		return s.saveResponse(ctx, tx, payment.IdempotencyKey, nil)
+	})
 	if err != nil {
 		return fmt.Errorf("%s: %w", fn, err)
 	}
 
-	limit, err := s.strg.Wallet().GetLimit(ctx, wallet.Type)
+	return nil
+}
+
+func (s *service) Withdraw(ctx context.Context, payment *models.PaymentReq) error {
+	const fn = "service.Withdraw"
+
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		if err := s.reserveKey(ctx, tx, payment.IdempotencyKey); err != nil {
+			return err
+		}
+
+		wallet, err := s.strg.Wallet().GetForUpdate(ctx, tx, payment.Owner)
+		if err != nil {
+			return err
+		}
+
+		if err := checkStatus(wallet, false); err != nil {
+			return err
+		}
+
+		amount, err := paymentAmount(wallet, payment.Amount, payment.Currency)
+		if err != nil {
+			return err
+		}
+
+		if amount > wallet.Balance {
+			return customerrors.ErrInsufficientFunds{Balance: wallet.Balance, Amount: amount, Currency: wallet.Currency}
+		}
+
+		if err := s.checkRules(ctx, tx, wallet, models.TxTypeWithdrawal, amount); err != nil {
+			return err
+		}
+
+		pay := &models.Payment{
+			Amount:   amount,
+			WalletID: wallet.ID,
+		}
+		transactionID, err := s.strg.Transaction().Withdraw(ctx, tx, pay)
+		if err != nil {
+			return err
+		}
+
+		if err := s.strg.Wallet().DecreaseBalance(ctx, tx, pay); err != nil {
+			return err
+		}
+
+		if err := s.postPayment(ctx, tx, models.EntryWithdrawal, transactionID, wallet, amount); err != nil {
+			return err
+		}
+
+		return s.saveResponse(ctx, tx, payment.IdempotencyKey, nil)
+	})
 	if err != nil {
 		return fmt.Errorf("%s: %w", fn, err)
 	}
 
-	smallestUnit := int(payment.Amount * 100)
-	if smallestUnit+wallet.Balance > limit.MaxAmount {
-		err := customerrors.ErrLimitExceeded{WalletType: limit.Name, MaxAmount: limit.MaxAmount}
-		return fmt.Errorf("%s: %w", fn, err)
+	return nil
+}
+
+func (s *service) Transfer(ctx context.Context, transfer *models.TransferReq) (*models.TransferResp, error) {
+	const fn = "service.Transfer"
+
+	fromID, err := s.strg.Wallet().GetWallet(ctx, transfer.From)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
 	}
 
-	tx, err := s.strg.Transaction().BeginTx(ctx)
+	toID, err := s.strg.Wallet().GetWallet(ctx, transfer.To)
 	if err != nil {
-		return fmt.Errorf("%s: %w", fn, err)
+		return nil, fmt.Errorf("%s: %w", fn, err)
 	}
-	defer tx.Rollback()
 
-	pay := &models.Payment{
-		Amount:   smallestUnit,
-		WalletID: wallet.ID,
+	if fromID == toID {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrSelfTransfer)
 	}
-	_, err = s.strg.Transaction().PutFunds(ctx, tx, pay)
+
+	res := &models.TransferResp{}
+	err = s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		if err := s.reserveKey(ctx, tx, transfer.IdempotencyKey); err != nil {
+			return err
+		}
+
+		// rows are always locked in the same order, so two opposite transfers
+		// between the same wallets can't deadlock each other
+		wallets := make(map[int]*models.Wallet, 2)
+		for _, id := range lockOrder(fromID, toID) {
+			wallet, err := s.strg.Wallet().GetByIDForUpdate(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			wallets[id] = wallet
+		}
+		sender, receiver := wallets[fromID], wallets[toID]
+
+		if err := checkStatus(sender, false); err != nil {
+			return err
+		}
+		if checkStatus(receiver, true) != nil {
+			return customerrors.ErrReceiverNotAccepting
+		}
+
+		// money isn't converted, both wallets must be kept in the same currency
+		amount, err := paymentAmount(sender, transfer.Amount, transfer.Currency)
+		if err != nil {
+			return err
+		}
+		if receiver.Currency != sender.Currency {
+			return customerrors.ErrCurrencyMismatch
+		}
+
+		if amount > sender.Balance {
+			return customerrors.ErrInsufficientFunds{Balance: sender.Balance, Amount: amount, Currency: sender.Currency}
+		}
+
+		if err := s.checkRules(ctx, tx, sender, models.TxTypeTransferOut, amount); err != nil {
+			return err
+		}
+		if err := s.checkRules(ctx, tx, receiver, models.TxTypeTransferIn, amount); err != nil {
+			return err
+		}
+
+		trnsfr := &models.Transfer{
+			Amount:       amount,
+			FromWalletID: sender.ID,
+			ToWalletID:   receiver.ID,
+		}
+		res.TransferID, err = s.strg.Transaction().Transfer(ctx, tx, trnsfr)
+		if err != nil {
+			return err
+		}
+
+		debit := &models.Payment{Amount: amount, WalletID: sender.ID}
+		if err := s.strg.Wallet().DecreaseBalance(ctx, tx, debit); err != nil {
+			return err
+		}
+
+		credit := &models.Payment{Amount: amount, WalletID: receiver.ID}
+		if err := s.strg.Wallet().UpdateBalance(ctx, tx, credit); err != nil {
+			return err
+		}
+
+		if err := s.postTransfer(ctx, tx, res.TransferID, sender, receiver, amount); err != nil {
+			return err
+		}
+
+		return s.saveResponse(ctx, tx, transfer.IdempotencyKey, res)
+	})
 	if err != nil {
-		return fmt.Errorf("%s: %w", fn, err)
+		return nil, fmt.Errorf("%s: %w", fn, err)
 	}
 
-	if err := s.strg.Wallet().UpdateBalance(ctx, tx, pay); err != nil {
-		return fmt.Errorf("%s: %w", fn, err)
-	}
+	return res, nil
+}
 
-	tx.Commit()
+// partnerActor names the partner in the audit log
+func partnerActor(partnerID int) string {
+	return fmt.Sprintf("partner:%d", partnerID)
+}
 
-	return nil
+// transactionRef, transferRef and walletRef name the operation or the wallet
+// a journal entry or a history record refers to
+func transactionRef(transactionID int) string {
+	return fmt.Sprintf("transaction:%d", transactionID)
 }
 
-func monthStart(now time.Time) time.Time {
-	year, month, location := now.Year(), now.Month(), now.Location()
-	return time.Date(year, month, 1, 0, 0, 0, 0, location)
+func transferRef(transferID int) string {
+	return fmt.Sprintf("transfer:%d", transferID)
 }
 
-func monthEnd(now time.Time) time.Time {
-	var lastDay int
-	year, month, location := now.Year(), now.Month(), now.Location()
+func walletRef(walletID int) string {
+	return fmt.Sprintf("wallet:%d", walletID)
+}
 
-	if month == 2 && (year%4 == 0 && year%100 != 0) || (year%400 == 0) {
-		lastDay = 29
-	} else if month == 2 {
-		lastDay = 28
-	} else if month == 1 || month == 3 || month == 5 || month == 7 || month == 8 || month == 10 || month == 12 {
-		lastDay = 31
-	} else {
-		lastDay = 30
+// lockOrder returns wallet ids in the order the wallets must be locked
+func lockOrder(a, b int) []int {
+	if a < b {
+		return []int{a, b}
 	}
+	return []int{b, a}
+}
+
+// monthRange returns the half-open range [start, end) of the month of t in
+// the location. Days of the month aren't counted by hand, time.Date
+// normalizes the next month for leap years and DST alike
+func monthRange(t time.Time, location *time.Location) (time.Time, time.Time) {
+	year, month, _ := t.In(location).Date()
+	start := time.Date(year, month, 1, 0, 0, 0, 0, location)
+
+	return start, start.AddDate(0, 1, 0)
+}
+
+// dayStart returns the midnight of the day of t in the location
+func dayStart(t time.Time, location *time.Location) time.Time {
+	year, month, day := t.In(location).Date()
 
-	return time.Date(year, month, lastDay, 23, 59, 59, int(time.Second-time.Nanosecond), location)
+	return time.Date(year, month, day, 0, 0, 0, 0, location)
 }
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // the zones below mustn't depend on the host
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location
+}
+
+func TestMonthRange(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	newYork := loadLocation(t, "America/New_York")
+	dushanbe := loadLocation(t, "Asia/Dushanbe")
+
+	tests := []struct {
+		name      string
+		t         time.Time
+		location  *time.Location
+		wantStart time.Time
+		wantEnd   time.Time
+		wantLen   time.Duration
+	}{
+		{
+			name:      "february of 1900 isn't leap",
+			t:         time.Date(1900, time.February, 28, 12, 0, 0, 0, time.UTC),
+			location:  time.UTC,
+			wantStart: time.Date(1900, time.February, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(1900, time.March, 1, 0, 0, 0, 0, time.UTC),
+			wantLen:   28 * 24 * time.Hour,
+		},
+		{
+			name:      "february of 2000 is leap",
+			t:         time.Date(2000, time.February, 29, 12, 0, 0, 0, time.UTC),
+			location:  time.UTC,
+			wantStart: time.Date(2000, time.February, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2000, time.March, 1, 0, 0, 0, 0, time.UTC),
+			wantLen:   29 * 24 * time.Hour,
+		},
+		{
+			name:      "february of 2024 is leap",
+			t:         time.Date(2024, time.February, 10, 0, 0, 0, 0, time.UTC),
+			location:  time.UTC,
+			wantStart: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			wantLen:   29 * 24 * time.Hour,
+		},
+		{
+			name:      "berlin springs forward in march",
+			t:         time.Date(2024, time.March, 31, 23, 30, 0, 0, berlin),
+			location:  berlin,
+			wantStart: time.Date(2024, time.March, 1, 0, 0, 0, 0, berlin),
+			wantEnd:   time.Date(2024, time.April, 1, 0, 0, 0, 0, berlin),
+			wantLen:   31*24*time.Hour - time.Hour,
+		},
+		{
+			name:      "berlin falls back in october",
+			t:         time.Date(2024, time.October, 27, 2, 30, 0, 0, time.UTC),
+			location:  berlin,
+			wantStart: time.Date(2024, time.October, 1, 0, 0, 0, 0, berlin),
+			wantEnd:   time.Date(2024, time.November, 1, 0, 0, 0, 0, berlin),
+			wantLen:   31*24*time.Hour + time.Hour,
+		},
+		{
+			name:      "new york springs forward in march",
+			t:         time.Date(2024, time.March, 10, 12, 0, 0, 0, newYork),
+			location:  newYork,
+			wantStart: time.Date(2024, time.March, 1, 0, 0, 0, 0, newYork),
+			wantEnd:   time.Date(2024, time.April, 1, 0, 0, 0, 0, newYork),
+			wantLen:   31*24*time.Hour - time.Hour,
+		},
+		{
+			name:      "new york falls back in november",
+			t:         time.Date(2024, time.November, 3, 1, 30, 0, 0, newYork),
+			location:  newYork,
+			wantStart: time.Date(2024, time.November, 1, 0, 0, 0, 0, newYork),
+			wantEnd:   time.Date(2024, time.December, 1, 0, 0, 0, 0, newYork),
+			wantLen:   30*24*time.Hour + time.Hour,
+		},
+		{
+			name:      "new york month is still running in utc",
+			t:         time.Date(2024, time.December, 1, 3, 0, 0, 0, time.UTC),
+			location:  newYork,
+			wantStart: time.Date(2024, time.November, 1, 0, 0, 0, 0, newYork),
+			wantEnd:   time.Date(2024, time.December, 1, 0, 0, 0, 0, newYork),
+			wantLen:   30*24*time.Hour + time.Hour,
+		},
+		{
+			name:      "dushanbe month starts before utc",
+			t:         time.Date(2024, time.January, 31, 20, 0, 0, 0, time.UTC),
+			location:  dushanbe,
+			wantStart: time.Date(2024, time.February, 1, 0, 0, 0, 0, dushanbe),
+			wantEnd:   time.Date(2024, time.March, 1, 0, 0, 0, 0, dushanbe),
+			wantLen:   29 * 24 * time.Hour,
+		},
+		{
+			name:      "dushanbe december rolls over the year",
+			t:         time.Date(2024, time.December, 31, 23, 59, 59, 0, dushanbe),
+			location:  dushanbe,
+			wantStart: time.Date(2024, time.December, 1, 0, 0, 0, 0, dushanbe),
+			wantEnd:   time.Date(2025, time.January, 1, 0, 0, 0, 0, dushanbe),
+			wantLen:   31 * 24 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := monthRange(tt.t, tt.location)
+
+			if !start.Equal(tt.wantStart) {
+				t.Errorf("monthRange() start = %v, want %v", start, tt.wantStart)
+			}
+			if !end.Equal(tt.wantEnd) {
+				t.Errorf("monthRange() end = %v, want %v", end, tt.wantEnd)
+			}
+			if got := end.Sub(start); got != tt.wantLen {
+				t.Errorf("monthRange() length = %v, want %v", got, tt.wantLen)
+			}
+
+			// the range is half-open: it holds t and its own start, while
+			// its end is the start of the next month
+			if tt.t.Before(start) || !tt.t.Before(end) {
+				t.Errorf("monthRange() = [%v, %v), doesn't hold %v", start, end, tt.t)
+			}
+			if gotStart, _ := monthRange(start, tt.location); !gotStart.Equal(start) {
+				t.Errorf("monthRange(%v) start = %v, want %v", start, gotStart, start)
+			}
+			last := end.Add(-time.Nanosecond)
+			if gotStart, _ := monthRange(last, tt.location); !gotStart.Equal(start) {
+				t.Errorf("monthRange(%v) start = %v, want %v", last, gotStart, start)
+			}
+			if nextStart, _ := monthRange(end, tt.location); !nextStart.Equal(end) {
+				t.Errorf("monthRange(%v) start = %v, want %v", end, nextStart, end)
+			}
+		})
+	}
+}
+
+func TestDayStart(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	newYork := loadLocation(t, "America/New_York")
+	dushanbe := loadLocation(t, "Asia/Dushanbe")
+
+	tests := []struct {
+		name     string
+		t        time.Time
+		location *time.Location
+		want     time.Time
+	}{
+		{
+			name:     "no leap day in 1900",
+			t:        time.Date(1900, time.February, 28, 12, 0, 0, 0, time.UTC).Add(24 * time.Hour),
+			location: time.UTC,
+			want:     time.Date(1900, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap day in 2000",
+			t:        time.Date(2000, time.February, 29, 23, 59, 59, 0, time.UTC),
+			location: time.UTC,
+			want:     time.Date(2000, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap day in 2024",
+			t:        time.Date(2024, time.February, 28, 12, 0, 0, 0, time.UTC).Add(24 * time.Hour),
+			location: time.UTC,
+			want:     time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "berlin day of springing forward",
+			t:        time.Date(2024, time.March, 31, 12, 0, 0, 0, berlin),
+			location: berlin,
+			want:     time.Date(2024, time.March, 31, 0, 0, 0, 0, berlin),
+		},
+		{
+			name:     "new york day of falling back",
+			t:        time.Date(2024, time.November, 3, 23, 0, 0, 0, newYork),
+			location: newYork,
+			want:     time.Date(2024, time.November, 3, 0, 0, 0, 0, newYork),
+		},
+		{
+			name:     "new york day is still running in utc",
+			t:        time.Date(2024, time.March, 11, 2, 0, 0, 0, time.UTC),
+			location: newYork,
+			want:     time.Date(2024, time.March, 10, 0, 0, 0, 0, newYork),
+		},
+		{
+			name:     "dushanbe day starts before utc",
+			t:        time.Date(2024, time.February, 28, 22, 0, 0, 0, time.UTC),
+			location: dushanbe,
+			want:     time.Date(2024, time.February, 29, 0, 0, 0, 0, dushanbe),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dayStart(tt.t, tt.location)
+
+			if !got.Equal(tt.want) {
+				t.Errorf("dayStart() = %v, want %v", got, tt.want)
+			}
+			if tt.t.Before(got) {
+				t.Errorf("dayStart() = %v, after %v", got, tt.t)
+			}
+			if again := dayStart(got, tt.location); !again.Equal(got) {
+				t.Errorf("dayStart(%v) = %v, want %v", got, again, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// ChangeWalletStatus moves the wallet to the requested status. Closing is
+// final and needs a zero balance, otherwise the balance is withdrawn to the
+// payout destination in the same transaction
+func (s *service) ChangeWalletStatus(ctx context.Context, req *models.WalletStatusReq) (*models.WalletStatusResp, error) {
+	const fn = "service.ChangeWalletStatus"
+
+	res := &models.WalletStatusResp{
+		WalletID: req.WalletID,
+		Status:   req.Status,
+		Reason:   req.Reason,
+	}
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		wallet, err := s.strg.Wallet().GetByPublicIDForUpdate(ctx, tx, req.WalletID)
+		if err != nil {
+			return err
+		}
+
+		if wallet.Status == models.WalletClosed {
+			return customerrors.ErrWalletClosed
+		}
+		if wallet.Status == req.Status {
+			return customerrors.ErrWalletStatusUnchanged
+		}
+
+		details := map[string]any{
+			"status":          req.Status,
+			"previous_status": wallet.Status,
+			"reason":          req.Reason,
+			"comment":         req.Comment,
+		}
+
+		currency := currencyOf(wallet.Currency)
+		res.Balance = currency.Format(wallet.Balance)
+		res.Currency = wallet.Currency
+		if req.Status == models.WalletClosed && wallet.Balance > 0 {
+			if req.PayoutDestination == "" {
+				return customerrors.ErrBalanceNotPaidOut
+			}
+
+			res.PayoutAmount = currency.Format(wallet.Balance)
+			res.PayoutTransactionID, err = s.payOut(ctx, tx, wallet, req.PayoutDestination)
+			if err != nil {
+				return err
+			}
+			res.Balance = currency.Format(0)
+
+			details["payout_destination"] = req.PayoutDestination
+			details["payout_amount"] = money.Money{Amount: wallet.Balance, Currency: wallet.Currency}.String()
+			details["payout_transaction_id"] = res.PayoutTransactionID
+		}
+
+		change := &models.WalletStatusChange{
+			WalletID: wallet.ID,
+			Status:   req.Status,
+			Reason:   req.Reason,
+		}
+		if err := s.strg.Wallet().UpdateStatus(ctx, tx, change); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    req.OperatorID,
+			Action:   models.AuditWalletStatusChanged,
+			Entity:   "wallet",
+			EntityID: strconv.Itoa(wallet.ID),
+			Details:  details,
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return res, nil
+}
+
+// payOut withdraws the whole balance of the locked wallet to the destination
+func (s *service) payOut(ctx context.Context, tx *sql.Tx, wallet *models.Wallet, destination string) (int, error) {
+	pay := &models.Payment{
+		Amount:   wallet.Balance,
+		WalletID: wallet.ID,
+	}
+	transactionID, err := s.strg.Transaction().Withdraw(ctx, tx, pay)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.strg.Wallet().DecreaseBalance(ctx, tx, pay); err != nil {
+		return 0, err
+	}
+
+	if err := s.postPayment(ctx, tx, models.EntryWithdrawal, transactionID, wallet, wallet.Balance); err != nil {
+		return 0, err
+	}
+
+	payout := &models.Payout{
+		WalletID:      wallet.ID,
+		TransactionID: transactionID,
+		Amount:        wallet.Balance,
+		Destination:   destination,
+	}
+	if _, err := s.strg.Wallet().AddPayout(ctx, tx, payout); err != nil {
+		return 0, err
+	}
+
+	return transactionID, nil
+}
+
+// checkStatus returns the error of the wallet's status if it forbids moving
+// money into the wallet (credit) or out of it
+func checkStatus(wallet *models.Wallet, credit bool) error {
+	switch wallet.Status {
+	case models.WalletFrozenCredit:
+		if credit {
+			return customerrors.ErrWalletFrozenCredit
+		}
+	case models.WalletFrozenDebit:
+		if !credit {
+			return customerrors.ErrWalletFrozenDebit
+		}
+	case models.WalletBlocked:
+		return customerrors.ErrWalletBlocked
+	case models.WalletClosed:
+		return customerrors.ErrWalletClosed
+	}
+
+	return nil
+}
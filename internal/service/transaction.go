@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+)
+
+// ListTransactions returns a page of the wallet's history, newest first, and
+// the cursor of the next page
+func (s *service) ListTransactions(ctx context.Context, req *models.TransactionsReq) (*models.TransactionsResp, error) {
+	const fn = "service.ListTransactions"
+
+	wallet, err := s.strg.Wallet().CheckBalance(ctx, req.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	filter := &models.TransactionFilter{
+		WalletID: wallet.ID,
+		From:     req.From,
+		To:       req.To,
+		Type:     req.Type,
+		// one more row tells whether there is a next page
+		Limit: req.Limit + 1,
+	}
+	// amount bounds are given in the wallet's currency
+	currency := currencyOf(wallet.Currency)
+	if req.MinAmount != "" {
+		if filter.MinAmount, err = req.MinAmount.Amount(currency); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+	if req.MaxAmount != "" {
+		if filter.MaxAmount, err = req.MaxAmount.Amount(currency); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+	// the bounds are compared in minor units, as precisely as they're applied
+	if req.MinAmount != "" && req.MaxAmount != "" && filter.MinAmount > filter.MaxAmount {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrInvalidAmountRange)
+	}
+	if req.Cursor != "" {
+		filter.After, err = decodeCursor(req.Cursor)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+
+	transactions, err := s.strg.Transaction().List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res := &models.TransactionsResp{
+		Transactions: make([]models.TransactionResp, 0, len(transactions)),
+	}
+	if len(transactions) > req.Limit {
+		transactions = transactions[:req.Limit]
+		last := transactions[len(transactions)-1]
+		res.NextCursor = encodeCursor(&models.TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	for _, transaction := range transactions {
+		res.Transactions = append(res.Transactions, transactionResp(&transaction))
+	}
+
+	return res, nil
+}
+
+func transactionResp(transaction *models.Transaction) models.TransactionResp {
+	currency := currencyOf(transaction.Currency)
+	resp := models.TransactionResp{
+		ID:                 transaction.ID,
+		Type:               transaction.Type,
+		Amount:             currency.Format(transaction.Amount),
+		Currency:           transaction.Currency,
+		Counterparty:       transaction.Counterparty,
+		CounterpartyWallet: transaction.CounterpartyWallet,
+		CreatedAt:          transaction.CreatedAt,
+	}
+	if transaction.Reversed > 0 {
+		resp.Reversed = currency.Format(transaction.Reversed)
+	}
+	switch {
+	case transaction.TransferID != 0:
+		resp.Reference = transferRef(transaction.TransferID)
+	case transaction.OriginalID != 0:
+		resp.Reference = transactionRef(transaction.OriginalID)
+	}
+
+	return resp
+}
+
+// encodeCursor hides the position in the history from the client, who only
+// passes it back
+func encodeCursor(cursor *models.TransactionCursor) string {
+	position := fmt.Sprintf("%d:%d", cursor.CreatedAt.UnixNano(), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+func decodeCursor(encoded string) (*models.TransactionCursor, error) {
+	position, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, customerrors.ErrInvalidCursor
+	}
+
+	createdAt, id, ok := strings.Cut(string(position), ":")
+	if !ok {
+		return nil, customerrors.ErrInvalidCursor
+	}
+
+	nanos, err := strconv.ParseInt(createdAt, 10, 64)
+	if err != nil {
+		return nil, customerrors.ErrInvalidCursor
+	}
+	transactionID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, customerrors.ErrInvalidCursor
+	}
+
+	cursor := &models.TransactionCursor{
+		CreatedAt: time.Unix(0, nanos).UTC(),
+		ID:        transactionID,
+	}
+
+	return cursor, nil
+}
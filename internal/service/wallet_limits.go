@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+)
+
+// GetWalletLimits returns the wallet's own limits in force and the changes
+// of them scheduled for later
+func (s *service) GetWalletLimits(ctx context.Context, walletID string) (*models.WalletLimitsResp, error) {
+	const fn = "service.GetWalletLimits"
+
+	wallet, err := s.strg.Wallet().GetByPublicID(ctx, walletID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res, err := s.getWalletLimits(ctx, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return res, nil
+}
+
+// UpdateWalletLimits schedules changes of the wallet's own limits. They take
+// precedence over the limits of the wallet's type, a lifted rule isn't
+// applied to the wallet even if its type has it
+func (s *service) UpdateWalletLimits(ctx context.Context, req *models.WalletLimitsReq) (*models.WalletLimitsResp, error) {
+	const fn = "service.UpdateWalletLimits"
+
+	var wallet *models.Wallet
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		wallet, err = s.strg.Wallet().GetByPublicIDForUpdate(ctx, tx, req.WalletID)
+		if err != nil {
+			return err
+		}
+
+		if wallet.Status == models.WalletClosed {
+			return customerrors.ErrWalletClosed
+		}
+		if req.Currency != wallet.Currency {
+			return customerrors.ErrCurrencyMismatch
+		}
+
+		changes, err := ruleChanges(0, &models.WalletTypeReq{
+			Currency:      req.Currency,
+			Limits:        req.Limits,
+			Lift:          req.Lift,
+			EffectiveFrom: req.EffectiveFrom,
+		})
+		if err != nil {
+			return err
+		}
+		for i := range changes {
+			changes[i].WalletID = wallet.ID
+		}
+		if err := s.strg.Rule().AddWalletRules(ctx, tx, changes); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    req.OperatorID,
+			Action:   models.AuditWalletLimitsUpdated,
+			Entity:   "wallet",
+			EntityID: strconv.Itoa(wallet.ID),
+			Details: map[string]any{
+				"currency":       req.Currency,
+				"limits":         req.Limits,
+				"lift":           req.Lift,
+				"effective_from": req.EffectiveFrom,
+			},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res, err := s.getWalletLimits(ctx, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return res, nil
+}
+
+// DeleteWalletLimit removes all versions of the wallet's own rule, including
+// the scheduled ones, so the rule of the wallet's type applies again
+func (s *service) DeleteWalletLimit(ctx context.Context, walletID, rule, operatorID string) error {
+	const fn = "service.DeleteWalletLimit"
+
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		wallet, err := s.strg.Wallet().GetByPublicIDForUpdate(ctx, tx, walletID)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := s.strg.Rule().DeleteWalletRule(ctx, tx, wallet.ID, rule)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return customerrors.ErrWalletLimitNotFound
+		}
+
+		record := &models.AuditRecord{
+			Actor:    operatorID,
+			Action:   models.AuditWalletLimitDeleted,
+			Entity:   "wallet",
+			EntityID: strconv.Itoa(wallet.ID),
+			Details: map[string]any{
+				"rule":     rule,
+				"versions": deleted,
+			},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+func (s *service) getWalletLimits(ctx context.Context, wallet *models.Wallet) (*models.WalletLimitsResp, error) {
+	changes, err := s.strg.Rule().ListWalletRules(ctx, wallet.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return walletLimitsResp(wallet, changes, time.Now()), nil
+}
+
+// walletLimitsResp is currencyLimitsResp of the wallet's own rules, which
+// also names the rules lifted for the wallet at now
+func walletLimitsResp(wallet *models.Wallet, changes []models.LimitRuleChange, now time.Time) *models.WalletLimitsResp {
+	limits := currencyLimitsResp(wallet.Currency, changes, now)
+	res := &models.WalletLimitsResp{
+		WalletID:  wallet.PublicID,
+		Currency:  limits.Currency,
+		Limits:    limits.Limits,
+		Scheduled: limits.Scheduled,
+	}
+
+	current := make(map[string]*int64)
+	var rules []string
+	for _, change := range changes {
+		if change.EffectiveFrom.After(now) {
+			continue
+		}
+		if _, ok := current[change.Rule]; !ok {
+			rules = append(rules, change.Rule)
+		}
+		current[change.Rule] = change.Value
+	}
+	for _, rule := range rules {
+		if current[rule] == nil {
+			res.Lifted = append(res.Lifted, rule)
+		}
+	}
+
+	return res
+}
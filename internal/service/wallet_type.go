@@ -0,0 +1,315 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// ListWalletTypes returns wallet types with their limits in force and the
+// changes scheduled for later
+func (s *service) ListWalletTypes(ctx context.Context) ([]models.WalletTypeResp, error) {
+	const fn = "service.ListWalletTypes"
+
+	walletTypes, err := s.strg.WalletType().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	changes, err := s.strg.Rule().ListTypeRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	byType := make(map[int][]models.LimitRuleChange, len(walletTypes))
+	for _, change := range changes {
+		byType[change.WalletType] = append(byType[change.WalletType], change)
+	}
+
+	now := time.Now()
+	res := make([]models.WalletTypeResp, 0, len(walletTypes))
+	for _, walletType := range walletTypes {
+		res = append(res, walletTypeResp(walletType, byType[walletType.ID], now))
+	}
+
+	return res, nil
+}
+
+func (s *service) CreateWalletType(ctx context.Context, req *models.WalletTypeReq) (*models.WalletTypeResp, error) {
+	const fn = "service.CreateWalletType"
+
+	var id int
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		id, err = s.strg.WalletType().Create(ctx, tx, req.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := s.addTypeRules(ctx, tx, id, req); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    req.OperatorID,
+			Action:   models.AuditWalletTypeCreated,
+			Entity:   "wallet_type",
+			EntityID: strconv.Itoa(id),
+			Details: map[string]any{
+				"name":           req.Name,
+				"currency":       req.Currency,
+				"limits":         req.Limits,
+				"effective_from": req.EffectiveFrom,
+			},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res, err := s.getWalletType(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return res, nil
+}
+
+// addTypeRules adds the limit changes of the request to the wallet type.
+// A max balance lets wallets of the type be opened in the currency, so
+// settlement, fee and suspense accounts are opened in it here rather than on
+// the first operation of such a wallet
+func (s *service) addTypeRules(ctx context.Context, tx *sql.Tx, walletType int, req *models.WalletTypeReq) error {
+	changes, err := ruleChanges(walletType, req)
+	if err != nil {
+		return err
+	}
+	if err := s.strg.Rule().AddTypeRules(ctx, tx, changes); err != nil {
+		return err
+	}
+
+	if req.Limits.MaxBalance == nil {
+		return nil
+	}
+
+	return s.strg.Ledger().OpenCurrencyAccounts(ctx, tx, req.Currency)
+}
+
+// UpdateWalletType renames the wallet type and schedules changes of its
+// limits in the currency. Unless forced, the max balance can't be set below
+// balances of the type's wallets, as they couldn't be topped up anymore
+func (s *service) UpdateWalletType(ctx context.Context, req *models.WalletTypeReq) (*models.WalletTypeResp, error) {
+	const fn = "service.UpdateWalletType"
+
+	err := s.strg.Transaction().RunInTx(ctx, func(tx *sql.Tx) error {
+		walletType, err := s.strg.WalletType().GetForUpdate(ctx, tx, req.ID)
+		if err != nil {
+			return err
+		}
+
+		if req.Name != "" && req.Name != walletType.Name {
+			if err := s.strg.WalletType().Rename(ctx, tx, walletType.ID, req.Name); err != nil {
+				return err
+			}
+		}
+
+		if req.Limits.MaxBalance != nil && !req.Force {
+			maxBalance, err := req.Limits.MaxBalance.Amount(currencyOf(req.Currency))
+			if err != nil {
+				return err
+			}
+
+			wallets, err := s.strg.Wallet().CountAboveBalance(ctx, tx, walletType.ID, req.Currency, maxBalance)
+			if err != nil {
+				return err
+			}
+			if wallets > 0 {
+				return customerrors.ErrBalancesAboveCap{Wallets: wallets}
+			}
+		}
+
+		if err := s.addTypeRules(ctx, tx, walletType.ID, req); err != nil {
+			return err
+		}
+
+		record := &models.AuditRecord{
+			Actor:    req.OperatorID,
+			Action:   models.AuditWalletTypeUpdated,
+			Entity:   "wallet_type",
+			EntityID: strconv.Itoa(walletType.ID),
+			Details: map[string]any{
+				"name":           req.Name,
+				"previous_name":  walletType.Name,
+				"currency":       req.Currency,
+				"limits":         req.Limits,
+				"lift":           req.Lift,
+				"effective_from": req.EffectiveFrom,
+				"force":          req.Force,
+			},
+		}
+		return s.strg.Audit().Add(ctx, tx, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	res, err := s.getWalletType(ctx, req.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return res, nil
+}
+
+func (s *service) getWalletType(ctx context.Context, id int) (*models.WalletTypeResp, error) {
+	walletType, err := s.strg.WalletType().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := s.strg.Rule().ListTypeRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var own []models.LimitRuleChange
+	for _, change := range changes {
+		if change.WalletType == id {
+			own = append(own, change)
+		}
+	}
+
+	res := walletTypeResp(*walletType, own, time.Now())
+	return &res, nil
+}
+
+// ruleChanges turns the limits and lifted rules of the request into new
+// versions of the type's rules in the request's currency
+func ruleChanges(walletType int, req *models.WalletTypeReq) ([]models.LimitRuleChange, error) {
+	var changes []models.LimitRuleChange
+	add := func(rule string, value *int64) {
+		changes = append(changes, models.LimitRuleChange{
+			WalletType:    walletType,
+			Currency:      req.Currency,
+			Rule:          rule,
+			Value:         value,
+			EffectiveFrom: req.EffectiveFrom,
+		})
+	}
+
+	currency := currencyOf(req.Currency)
+	for _, limit := range []struct {
+		rule   string
+		amount *money.Decimal
+	}{
+		{models.RuleMinAmount, req.Limits.MinAmount},
+		{models.RuleMaxAmount, req.Limits.MaxAmount},
+		{models.RuleDailyTopUp, req.Limits.DailyTopUp},
+		{models.RuleMonthlyTopUp, req.Limits.MonthlyTopUp},
+		{models.RuleMaxBalance, req.Limits.MaxBalance},
+	} {
+		if limit.amount != nil {
+			amount, err := limit.amount.Amount(currency)
+			if err != nil {
+				return nil, err
+			}
+			value := int64(amount)
+			add(limit.rule, &value)
+		}
+	}
+	if req.Limits.DailyOperations != nil {
+		value := int64(*req.Limits.DailyOperations)
+		add(models.RuleDailyOperations, &value)
+	}
+	for _, rule := range req.Lift {
+		add(rule, nil)
+	}
+
+	return changes, nil
+}
+
+// walletTypeResp splits versions of the type's rules, ordered by currency
+// and the time they take effect, into the limits in force at now and the
+// later changes of every currency
+func walletTypeResp(walletType models.WalletType, changes []models.LimitRuleChange, now time.Time) models.WalletTypeResp {
+	res := models.WalletTypeResp{
+		ID:         walletType.ID,
+		Name:       walletType.Name,
+		Currencies: []models.CurrencyLimitsResp{},
+	}
+
+	for len(changes) > 0 {
+		end := 1
+		for end < len(changes) && changes[end].Currency == changes[0].Currency {
+			end++
+		}
+		res.Currencies = append(res.Currencies, currencyLimitsResp(changes[0].Currency, changes[:end], now))
+		changes = changes[end:]
+	}
+
+	return res
+}
+
+// currencyLimitsResp is walletTypeResp of the rules in a single currency
+func currencyLimitsResp(code string, changes []models.LimitRuleChange, now time.Time) models.CurrencyLimitsResp {
+	res := models.CurrencyLimitsResp{
+		Currency:  code,
+		Scheduled: []models.ScheduledLimitsResp{},
+	}
+
+	currency := currencyOf(code)
+	current := make(map[string]*int64)
+	for _, change := range changes {
+		if !change.EffectiveFrom.After(now) {
+			current[change.Rule] = change.Value
+			continue
+		}
+
+		last := len(res.Scheduled) - 1
+		if last < 0 || !res.Scheduled[last].EffectiveFrom.Equal(change.EffectiveFrom) {
+			res.Scheduled = append(res.Scheduled, models.ScheduledLimitsResp{EffectiveFrom: change.EffectiveFrom})
+			last++
+		}
+
+		if change.Value == nil {
+			res.Scheduled[last].Lifted = append(res.Scheduled[last].Lifted, change.Rule)
+		} else {
+			setLimit(&res.Scheduled[last].Limits, currency, change.Rule, *change.Value)
+		}
+	}
+
+	for rule, value := range current {
+		if value != nil {
+			setLimit(&res.Limits, currency, rule, *value)
+		}
+	}
+
+	return res
+}
+
+func setLimit(limits *models.WalletTypeLimits, currency money.Currency, rule string, value int64) {
+	amount := currency.Format(money.Amount(value))
+
+	switch rule {
+	case models.RuleMinAmount:
+		limits.MinAmount = &amount
+	case models.RuleMaxAmount:
+		limits.MaxAmount = &amount
+	case models.RuleDailyTopUp:
+		limits.DailyTopUp = &amount
+	case models.RuleMonthlyTopUp:
+		limits.MonthlyTopUp = &amount
+	case models.RuleMaxBalance:
+		limits.MaxBalance = &amount
+	case models.RuleDailyOperations:
+		operations := int(value)
+		limits.DailyOperations = &operations
+	}
+}
@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+)
+
+type auditRepo struct {
+	db *sql.DB
+}
+
+func newAuditRepo(db *sql.DB) *auditRepo {
+	return &auditRepo{
+		db: db,
+	}
+}
+
+// Add writes the audit record as part of the transaction it describes
+func (r *auditRepo) Add(ctx context.Context, tx *sql.Tx, record *models.AuditRecord) error {
+	const fn = "storage.postgres.AddAudit"
+
+	details, err := json.Marshal(record.Details)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	query := `INSERT INTO audit_log(actor, action, entity, entity_id, details) VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, query, record.Actor, record.Action, record.Entity, record.EntityID, details)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+)
+
+type idempotencyRepo struct {
+	db *sql.DB
+}
+
+func newIdempotencyRepo(db *sql.DB) *idempotencyRepo {
+	return &idempotencyRepo{
+		db: db,
+	}
+}
+
+// Get returns the stored record of the key, or nil if the key isn't taken
+// or has expired
+func (r *idempotencyRepo) Get(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	const fn = "storage.postgres.Get"
+
+	query := `SELECT key, partner_id, user_id, request_hash, status_code, response_body, expires_at
+	FROM idempotency_keys WHERE partner_id = $1 AND user_id = $2 AND key = $3 AND expires_at >= NOW()`
+
+	existing, err := scanIdempotencyKey(r.db.QueryRowContext(ctx, query, key.PartnerID, key.UserID, key.Key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return existing, nil
+}
+
+// Reserve stores the key as in progress in the transaction of the operation
+// made with it. If the key is already taken and not expired, the stored
+// record is returned instead
+func (r *idempotencyRepo) Reserve(ctx context.Context, tx *sql.Tx, key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	const fn = "storage.postgres.Reserve"
+
+	query := `DELETE FROM idempotency_keys
+	WHERE partner_id = $1 AND user_id = $2 AND key = $3 AND expires_at < NOW()`
+	if _, err := tx.ExecContext(ctx, query, key.PartnerID, key.UserID, key.Key); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	// a concurrent transaction holding the key makes the insert wait for it
+	query = `INSERT INTO idempotency_keys(key, partner_id, user_id, request_hash, expires_at)
+	VALUES ($1, $2, $3, $4, $5) ON CONFLICT (partner_id, user_id, key) DO NOTHING`
+	res, err := tx.ExecContext(ctx, query, key.Key, key.PartnerID, key.UserID, key.RequestHash, key.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	if inserted == 1 {
+		return nil, nil
+	}
+
+	query = `SELECT key, partner_id, user_id, request_hash, status_code, response_body, expires_at
+	FROM idempotency_keys WHERE partner_id = $1 AND user_id = $2 AND key = $3`
+
+	existing, err := scanIdempotencyKey(tx.QueryRowContext(ctx, query, key.PartnerID, key.UserID, key.Key))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return existing, nil
+}
+
+// Save stores the response of the operation made with the key reserved in
+// the same transaction
+func (r *idempotencyRepo) Save(ctx context.Context, tx *sql.Tx, key *models.IdempotencyKey) error {
+	const fn = "storage.postgres.Save"
+
+	query := `UPDATE idempotency_keys SET status_code = $4, response_body = $5
+	WHERE partner_id = $1 AND user_id = $2 AND key = $3`
+	_, err := tx.ExecContext(ctx, query, key.PartnerID, key.UserID, key.Key, key.StatusCode, key.ResponseBody)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// SaveRejected stores the response to a request rejected without changing
+// anything. A key taken by a committed operation keeps its response
+func (r *idempotencyRepo) SaveRejected(ctx context.Context, key *models.IdempotencyKey) error {
+	const fn = "storage.postgres.SaveRejected"
+
+	query := `INSERT INTO idempotency_keys(key, partner_id, user_id, request_hash, status_code, response_body, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (partner_id, user_id, key) DO UPDATE SET
+		request_hash = EXCLUDED.request_hash,
+		status_code = EXCLUDED.status_code,
+		response_body = EXCLUDED.response_body,
+		created_at = NOW(),
+		expires_at = EXCLUDED.expires_at
+	WHERE idempotency_keys.expires_at < NOW()`
+	_, err := r.db.ExecContext(ctx, query,
+		key.Key,
+		key.PartnerID,
+		key.UserID,
+		key.RequestHash,
+		key.StatusCode,
+		key.ResponseBody,
+		key.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+func scanIdempotencyKey(row rowScanner) (*models.IdempotencyKey, error) {
+	var (
+		statusCode sql.NullInt64
+		key        = &models.IdempotencyKey{}
+	)
+	err := row.Scan(
+		&key.Key,
+		&key.PartnerID,
+		&key.UserID,
+		&key.RequestHash,
+		&statusCode,
+		&key.ResponseBody,
+		&key.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode.Valid {
+		key.StatusCode = int(statusCode.Int64)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+)
+
+const identificationColumns = `i.id, i.wallet_id, w.user_id, i.status, i.full_name, i.document_type,
+	i.document_number, i.birth_date, COALESCE(i.reviewed_by, ''), COALESCE(i.reject_reason, ''), i.created_at`
+
+type identificationRepo struct {
+	db *sql.DB
+}
+
+func newIdentificationRepo(db *sql.DB) *identificationRepo {
+	return &identificationRepo{
+		db: db,
+	}
+}
+
+// Create adds a new pending identification request of the wallet
+func (r *identificationRepo) Create(ctx context.Context, tx *sql.Tx, identification *models.Identification) (int, error) {
+	const fn = "storage.postgres.CreateIdentification"
+
+	var id int
+	query := `INSERT INTO identification_requests(wallet_id, full_name, document_type, document_number, birth_date)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		identification.WalletID,
+		identification.FullName,
+		identification.DocumentType,
+		identification.DocumentNumber,
+		identification.BirthDate,
+	).Scan(&id)
+	if pqErrorCode(err) == pqUniqueViolation {
+		return 0, fmt.Errorf("%s: %w", fn, customerrors.ErrIdentificationPending)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// GetLatest returns the last identification request of the wallet
+func (r *identificationRepo) GetLatest(ctx context.Context, walletID int) (*models.Identification, error) {
+	const fn = "storage.postgres.GetLatestIdentification"
+
+	query := `SELECT ` + identificationColumns + ` FROM identification_requests i
+	JOIN wallets w ON w.id = i.wallet_id
+	WHERE i.wallet_id = $1 ORDER BY i.id DESC LIMIT 1`
+
+	identification, err := scanIdentification(r.db.QueryRowContext(ctx, query, walletID))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return identification, nil
+}
+
+// GetForUpdate returns the identification request, locking it until tx ends
+func (r *identificationRepo) GetForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.Identification, error) {
+	const fn = "storage.postgres.GetIdentificationForUpdate"
+
+	query := `SELECT ` + identificationColumns + ` FROM identification_requests i
+	JOIN wallets w ON w.id = i.wallet_id
+	WHERE i.id = $1 FOR UPDATE OF i`
+
+	identification, err := scanIdentification(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return identification, nil
+}
+
+// List returns identification requests with the given status, oldest first
+func (r *identificationRepo) List(ctx context.Context, status string) ([]models.Identification, error) {
+	const fn = "storage.postgres.ListIdentifications"
+
+	query := `SELECT ` + identificationColumns + ` FROM identification_requests i
+	JOIN wallets w ON w.id = i.wallet_id
+	WHERE i.status = $1 ORDER BY i.id`
+
+	rows, err := r.db.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	result := make([]models.Identification, 0)
+	for rows.Next() {
+		identification, err := scanIdentification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		result = append(result, *identification)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return result, nil
+}
+
+// Review stores operator's decision on the identification request
+func (r *identificationRepo) Review(ctx context.Context, tx *sql.Tx, identification *models.Identification) error {
+	const fn = "storage.postgres.ReviewIdentification"
+
+	query := `UPDATE identification_requests
+	SET status = $2, reviewed_by = $3, reject_reason = NULLIF($4, ''), reviewed_at = NOW()
+	WHERE id = $1`
+
+	_, err := tx.ExecContext(
+		ctx,
+		query,
+		identification.ID,
+		identification.Status,
+		identification.ReviewedBy,
+		identification.RejectReason,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanIdentification(row rowScanner) (*models.Identification, error) {
+	identification := &models.Identification{}
+
+	err := row.Scan(
+		&identification.ID,
+		&identification.WalletID,
+		&identification.UserID,
+		&identification.Status,
+		&identification.FullName,
+		&identification.DocumentType,
+		&identification.DocumentNumber,
+		&identification.BirthDate,
+		&identification.ReviewedBy,
+		&identification.RejectReason,
+		&identification.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, customerrors.ErrIdentificationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return identification, nil
+}
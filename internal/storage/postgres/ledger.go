@@ -0,0 +1,229 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+type ledgerRepo struct {
+	db *sql.DB
+}
+
+func newLedgerRepo(db *sql.DB) *ledgerRepo {
+	return &ledgerRepo{
+		db: db,
+	}
+}
+
+// CreateWalletAccount opens the ledger account of the new wallet
+func (r *ledgerRepo) CreateWalletAccount(ctx context.Context, tx *sql.Tx, wallet *models.Wallet) (int, error) {
+	const fn = "storage.postgres.CreateWalletAccount"
+
+	var id int
+	query := "INSERT INTO accounts(type, currency, partner_id, wallet_id, balance) VALUES ($1, $2, $3, $4, 0) RETURNING id"
+
+	err := tx.QueryRowContext(ctx, query, models.AccountWallet, wallet.Currency, wallet.PartnerID, wallet.ID).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// GetWalletAccount returns the wallet's ledger account with its cached balance
+func (r *ledgerRepo) GetWalletAccount(ctx context.Context, tx *sql.Tx, walletID int) (*models.Account, error) {
+	const fn = "storage.postgres.GetWalletAccount"
+
+	account := &models.Account{}
+	query := "SELECT id, balance FROM accounts WHERE wallet_id = $1"
+
+	err := tx.QueryRowContext(ctx, query, walletID).Scan(&account.ID, &account.Balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrAccountNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return account, nil
+}
+
+// GetSettlementAccount returns the id of the partner's settlement account in
+// the currency
+func (r *ledgerRepo) GetSettlementAccount(ctx context.Context, tx *sql.Tx, partnerID int, currency string) (int, error) {
+	const fn = "storage.postgres.GetSettlementAccount"
+
+	var id int
+	query := "SELECT id FROM accounts WHERE type = $1 AND partner_id = $2 AND currency = $3"
+
+	err := tx.QueryRowContext(ctx, query, models.AccountSettlement, partnerID, currency).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s: %w", fn, customerrors.ErrAccountNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// GetSystemAccount returns the id of the fee or the suspense account in the
+// currency
+func (r *ledgerRepo) GetSystemAccount(ctx context.Context, tx *sql.Tx, accountType, currency string) (int, error) {
+	const fn = "storage.postgres.GetSystemAccount"
+
+	var id int
+	query := "SELECT id FROM accounts WHERE type = $1 AND currency = $2"
+
+	err := tx.QueryRowContext(ctx, query, accountType, currency).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s: %w", fn, customerrors.ErrAccountNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// OpenCurrencyAccounts opens the accounts the ledger needs in the currency:
+// settlement accounts of the partners which don't have them yet, the fee
+// account and the suspense account
+func (r *ledgerRepo) OpenCurrencyAccounts(ctx context.Context, tx *sql.Tx, currency string) error {
+	const fn = "storage.postgres.OpenCurrencyAccounts"
+
+	query := `INSERT INTO accounts(type, currency, partner_id) SELECT $1, $2, id FROM partners
+	ON CONFLICT (partner_id, currency) WHERE type = 'settlement' DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, models.AccountSettlement, currency); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	query = `INSERT INTO accounts(type, currency) VALUES ($1, $3), ($2, $3)
+	ON CONFLICT (type, currency) WHERE type IN ('fee', 'suspense') DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, models.AccountFee, models.AccountSuspense, currency); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// Post writes the journal entry and updates cached balances of its wallet
+// accounts. Settlement, fee and suspense accounts are left intact, so
+// operations of different wallets don't conflict on them
+func (r *ledgerRepo) Post(ctx context.Context, tx *sql.Tx, entry *models.JournalEntry) (int, error) {
+	const fn = "storage.postgres.Post"
+
+	var sum money.Amount
+	for _, posting := range entry.Postings {
+		sum += posting.Amount
+	}
+	if sum != 0 || len(entry.Postings) == 0 {
+		return 0, fmt.Errorf("%s: %w", fn, customerrors.ErrUnbalancedEntry)
+	}
+
+	var id int
+	query := "INSERT INTO journal_entries(kind, reference) VALUES ($1, $2) RETURNING id"
+
+	err := tx.QueryRowContext(ctx, query, entry.Kind, entry.Reference).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	for _, posting := range entry.Postings {
+		query := "INSERT INTO postings(entry_id, account_id, amount) VALUES ($1, $2, $3)"
+		if _, err := tx.ExecContext(ctx, query, id, posting.AccountID, posting.Amount); err != nil {
+			return 0, fmt.Errorf("%s: %w", fn, err)
+		}
+
+		query = "UPDATE accounts SET balance = balance + $2 WHERE id = $1 AND type = 'wallet'"
+		if _, err := tx.ExecContext(ctx, query, posting.AccountID, posting.Amount); err != nil {
+			return 0, fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+
+	return id, nil
+}
+
+// Check verifies on a single snapshot that the ledger of every currency sums
+// to zero, every entry is balanced and cached balances of wallet accounts
+// match the postings
+func (r *ledgerRepo) Check(ctx context.Context) (*models.LedgerCheck, error) {
+	const fn = "storage.postgres.CheckLedger"
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer tx.Rollback()
+
+	result := &models.LedgerCheck{Totals: make(map[string]money.Amount)}
+	query := `SELECT a.currency, SUM(p.amount) FROM postings p
+	JOIN accounts a ON a.id = p.account_id
+	GROUP BY a.currency`
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			currency string
+			total    money.Amount
+		)
+		if err := rows.Scan(&currency, &total); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		result.Totals[currency] = total
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	query = "SELECT entry_id FROM postings GROUP BY entry_id HAVING SUM(amount) <> 0 ORDER BY entry_id"
+	if result.UnbalancedEntries, err = queryIDs(ctx, tx, query); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	query = `SELECT a.id FROM accounts a
+	LEFT JOIN postings p ON p.account_id = a.id
+	WHERE a.type = 'wallet'
+	GROUP BY a.id HAVING a.balance <> COALESCE(SUM(p.amount), 0) ORDER BY a.id`
+	if result.AccountMismatches, err = queryIDs(ctx, tx, query); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	query = `SELECT w.id FROM wallets w
+	LEFT JOIN accounts a ON a.wallet_id = w.id
+	WHERE a.id IS NULL OR a.balance <> w.balance ORDER BY w.id`
+	if result.WalletMismatches, err = queryIDs(ctx, tx, query); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return result, nil
+}
+
+func queryIDs(ctx context.Context, tx *sql.Tx, query string) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+)
+
+type nonceRepo struct {
+	db *sql.DB
+}
+
+func newNonceRepo(db *sql.DB) *nonceRepo {
+	return &nonceRepo{
+		db: db,
+	}
+}
+
+// Use remembers the partner's nonce until expiresAt. It fails if the nonce
+// is already remembered. An expired nonce which isn't purged yet is taken
+// over rather than rejected
+func (r *nonceRepo) Use(ctx context.Context, partnerID int, nonce string, expiresAt time.Time) error {
+	const fn = "storage.postgres.UseNonce"
+
+	query := `INSERT INTO request_nonces(partner_id, nonce, expires_at) VALUES ($1, $2, $3)
+	ON CONFLICT (partner_id, nonce) DO UPDATE SET expires_at = EXCLUDED.expires_at
+	WHERE request_nonces.expires_at < NOW()`
+	res, err := r.db.ExecContext(ctx, query, partnerID, nonce, expiresAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	if inserted == 0 {
+		return fmt.Errorf("%s: %w", fn, customerrors.ErrNonceReused)
+	}
+
+	return nil
+}
+
+// Purge forgets expired nonces and returns how many of them were removed
+func (r *nonceRepo) Purge(ctx context.Context) (int64, error) {
+	const fn = "storage.postgres.PurgeNonces"
+
+	res, err := r.db.ExecContext(ctx, "DELETE FROM request_nonces WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return purged, nil
+}
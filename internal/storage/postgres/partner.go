@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+)
+
+const partnerKeyColumns = `id, partner_id, key_id, secret_key, not_before, not_after, revoked_at, created_at`
+
+type partnerRepo struct {
+	db *sql.DB
+}
+
+func newPartnerRepo(db *sql.DB) *partnerRepo {
+	return &partnerRepo{
+		db: db,
+	}
+}
+
+// GetPartner returns partner by id, without its keys
+func (r *partnerRepo) GetPartner(ctx context.Context, id int) (*models.Partner, error) {
+	const fn = "storage.postgres.GetPartner"
+
+	partner := &models.Partner{}
+	query := "SELECT id, name, signature_alg, fee_bps FROM partners WHERE id = $1"
+
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&partner.ID, &partner.Name, &partner.SignatureAlg, &partner.FeeBPS)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrPartnerNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return partner, nil
+}
+
+// GetActiveKeys returns partner's keys which are valid right now
+func (r *partnerRepo) GetActiveKeys(ctx context.Context, partnerID int) ([]models.PartnerKey, error) {
+	const fn = "storage.postgres.GetActiveKeys"
+
+	query := `SELECT ` + partnerKeyColumns + ` FROM partner_keys
+	WHERE partner_id = $1 AND revoked_at IS NULL
+		AND not_before <= NOW() AND (not_after IS NULL OR not_after > NOW())
+	ORDER BY not_before DESC`
+
+	keys, err := r.queryKeys(ctx, query, partnerID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return keys, nil
+}
+
+// ListKeys returns all partner's keys, including expired and revoked ones
+func (r *partnerRepo) ListKeys(ctx context.Context, partnerID int) ([]models.PartnerKey, error) {
+	const fn = "storage.postgres.ListKeys"
+
+	query := `SELECT ` + partnerKeyColumns + ` FROM partner_keys WHERE partner_id = $1 ORDER BY id`
+
+	keys, err := r.queryKeys(ctx, query, partnerID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return keys, nil
+}
+
+// CreateKey adds a new key of the partner
+func (r *partnerRepo) CreateKey(ctx context.Context, tx *sql.Tx, key *models.PartnerKey) (int, error) {
+	const fn = "storage.postgres.CreateKey"
+
+	var id int
+	query := `INSERT INTO partner_keys(partner_id, key_id, secret_key, not_before, not_after)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		key.PartnerID,
+		key.KeyID,
+		key.SecretKey,
+		key.NotBefore,
+		key.NotAfter,
+	).Scan(&id)
+	if pqErrorCode(err) == pqUniqueViolation {
+		return 0, fmt.Errorf("%s: %w", fn, customerrors.ErrPartnerKeyExists)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// RevokeKey stops accepting signatures made with the key
+func (r *partnerRepo) RevokeKey(ctx context.Context, tx *sql.Tx, partnerID int, keyID string) error {
+	const fn = "storage.postgres.RevokeKey"
+
+	query := `UPDATE partner_keys SET revoked_at = NOW()
+	WHERE partner_id = $1 AND key_id = $2 AND revoked_at IS NULL`
+
+	res, err := tx.ExecContext(ctx, query, partnerID, keyID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%s: %w", fn, customerrors.ErrPartnerKeyNotFound)
+	}
+
+	return nil
+}
+
+func (r *partnerRepo) queryKeys(ctx context.Context, query string, args ...any) ([]models.PartnerKey, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]models.PartnerKey, 0)
+	for rows.Next() {
+		var (
+			key       models.PartnerKey
+			notAfter  sql.NullTime
+			revokedAt sql.NullTime
+		)
+
+		err := rows.Scan(
+			&key.ID,
+			&key.PartnerID,
+			&key.KeyID,
+			&key.SecretKey,
+			&key.NotBefore,
+			&notAfter,
+			&revokedAt,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if notAfter.Valid {
+			key.NotAfter = &notAfter.Time
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
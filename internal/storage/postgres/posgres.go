@@ -3,18 +3,33 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/lib/pq"
 	"github.com/parviz-yu/digital-wallet/internal/config"
 	"github.com/parviz-yu/digital-wallet/internal/storage"
+)
 
-	_ "github.com/lib/pq"
+const (
+	pqUniqueViolation      = "23505"
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
 )
 
 type store struct {
 	db                *sql.DB
 	walletRepo        *walletRepo
 	replanishmentRepo *txRepo
+	idempotencyRepo   *idempotencyRepo
+	auditRepo         *auditRepo
+	identRepo         *identificationRepo
+	partnerRepo       *partnerRepo
+	nonceRepo         *nonceRepo
+	ledgerRepo        *ledgerRepo
+	reconRepo         *reconciliationRepo
+	ruleRepo          *ruleRepo
+	walletTypeRepo    *walletTypeRepo
 }
 
 func NewStorage(ctx context.Context, cfg config.Config) (storage.StorageI, error) {
@@ -38,14 +53,23 @@ func NewStorage(ctx context.Context, cfg config.Config) (storage.StorageI, error
 		return nil, fmt.Errorf("%s: %w", fn, err)
 	}
 
-	return newStorage(db), nil
+	return newStorage(db, cfg.Database), nil
 }
 
-func newStorage(db *sql.DB) *store {
+func newStorage(db *sql.DB, cfg config.Database) *store {
 	return &store{
 		db:                db,
 		walletRepo:        newWalletRepo(db),
-		replanishmentRepo: newReplanishmentRepo(db),
+		replanishmentRepo: newReplanishmentRepo(db, cfg.TxMaxRetries, cfg.TxRetryBackoff, cfg.TxMaxBackoff),
+		idempotencyRepo:   newIdempotencyRepo(db),
+		auditRepo:         newAuditRepo(db),
+		identRepo:         newIdentificationRepo(db),
+		partnerRepo:       newPartnerRepo(db),
+		nonceRepo:         newNonceRepo(db),
+		ledgerRepo:        newLedgerRepo(db),
+		reconRepo:         newReconciliationRepo(db),
+		ruleRepo:          newRuleRepo(db),
+		walletTypeRepo:    newWalletTypeRepo(db),
 	}
 }
 
@@ -60,3 +84,48 @@ func (s *store) Wallet() storage.WalletRepoI {
 func (s *store) Transaction() storage.TxRepoI {
 	return s.replanishmentRepo
 }
+
+func (s *store) Idempotency() storage.IdempotencyRepoI {
+	return s.idempotencyRepo
+}
+
+func (s *store) Audit() storage.AuditRepoI {
+	return s.auditRepo
+}
+
+func (s *store) Identification() storage.IdentificationRepoI {
+	return s.identRepo
+}
+
+func (s *store) Partner() storage.PartnerRepoI {
+	return s.partnerRepo
+}
+
+func (s *store) Nonce() storage.NonceRepoI {
+	return s.nonceRepo
+}
+
+func (s *store) Ledger() storage.LedgerRepoI {
+	return s.ledgerRepo
+}
+
+func (s *store) Reconciliation() storage.ReconciliationRepoI {
+	return s.reconRepo
+}
+
+func (s *store) Rule() storage.RuleRepoI {
+	return s.ruleRepo
+}
+
+func (s *store) WalletType() storage.WalletTypeRepoI {
+	return s.walletTypeRepo
+}
+
+func pqErrorCode(err error) string {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return ""
+	}
+
+	return string(pqErr.Code)
+}
@@ -0,0 +1,97 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+)
+
+type reconciliationRepo struct {
+	db *sql.DB
+}
+
+func newReconciliationRepo(db *sql.DB) *reconciliationRepo {
+	return &reconciliationRepo{
+		db: db,
+	}
+}
+
+// FindDrifts compares, on a single snapshot, stored wallet balances with
+// the sums of their transactions. It returns the number of checked wallets
+// and the ones which drifted
+func (r *reconciliationRepo) FindDrifts(ctx context.Context) (int, []models.BalanceDrift, error) {
+	const fn = "storage.postgres.FindDrifts"
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer tx.Rollback()
+
+	var checked int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM wallets").Scan(&checked); err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	query := `SELECT w.id, w.currency, w.balance, e.expected FROM wallets w
+	CROSS JOIN LATERAL (
+		SELECT COALESCE(SUM(CASE WHEN t.type IN ($1, $2) THEN t.amount ELSE -t.amount END), 0) AS expected
+		FROM transactions t WHERE t.wallet_id = w.id
+	) e
+	WHERE w.balance <> e.expected ORDER BY w.id`
+
+	rows, err := tx.QueryContext(ctx, query, models.TxTypeTopUp, models.TxTypeTransferIn)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	drifts := make([]models.BalanceDrift, 0)
+	for rows.Next() {
+		var drift models.BalanceDrift
+		if err := rows.Scan(&drift.WalletID, &drift.Currency, &drift.Balance, &drift.Expected); err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		drifts = append(drifts, drift)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return checked, drifts, nil
+}
+
+// SaveRun writes the report of the reconciliation run
+func (r *reconciliationRepo) SaveRun(ctx context.Context, run *models.ReconciliationRun) (int, error) {
+	const fn = "storage.postgres.SaveRun"
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	query := "INSERT INTO reconciliation_runs(wallets_checked, drifts) VALUES ($1, $2) RETURNING id"
+
+	err = tx.QueryRowContext(ctx, query, run.WalletsChecked, len(run.Drifts)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	for _, drift := range run.Drifts {
+		query := `INSERT INTO balance_drifts(run_id, wallet_id, balance, expected, entry_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0))`
+		if _, err := tx.ExecContext(ctx, query, id, drift.WalletID, drift.Balance, drift.Expected, drift.EntryID); err != nil {
+			return 0, fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+)
+
+type ruleRepo struct {
+	db *sql.DB
+}
+
+func newRuleRepo(db *sql.DB) *ruleRepo {
+	return &ruleRepo{
+		db: db,
+	}
+}
+
+// GetWalletRules returns the limit rules applied to the wallet now. The
+// wallet's own overrides take precedence over the rules of its type in the
+// wallet's currency
+func (r *ruleRepo) GetWalletRules(ctx context.Context, tx *sql.Tx, wallet *models.Wallet) ([]models.LimitRule, error) {
+	const fn = "storage.postgres.GetWalletRules"
+
+	query := `SELECT rule, value FROM (
+		SELECT DISTINCT ON (rule) rule, value FROM limit_rules
+		WHERE (wallet_id = $1 OR (wallet_type = $2 AND currency = $3)) AND effective_from <= NOW()
+		ORDER BY rule, wallet_id IS NULL, effective_from DESC
+	) rules
+	WHERE value IS NOT NULL`
+
+	rows, err := tx.QueryContext(ctx, query, wallet.ID, wallet.Type, wallet.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	var rules []models.LimitRule
+	for rows.Next() {
+		var rule models.LimitRule
+		if err := rows.Scan(&rule.Rule, &rule.Value); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return rules, nil
+}
+
+// ListTypeRules returns all versions of the wallet types' rules, ordered by
+// the time they take effect
+func (r *ruleRepo) ListTypeRules(ctx context.Context) ([]models.LimitRuleChange, error) {
+	const fn = "storage.postgres.ListTypeRules"
+
+	query := `SELECT wallet_type, currency, rule, value, effective_from FROM limit_rules
+	WHERE wallet_type IS NOT NULL
+	ORDER BY wallet_type, currency, effective_from, rule`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	var changes []models.LimitRuleChange
+	for rows.Next() {
+		var (
+			change models.LimitRuleChange
+			value  sql.NullInt64
+		)
+		if err := rows.Scan(&change.WalletType, &change.Currency, &change.Rule, &value, &change.EffectiveFrom); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		if value.Valid {
+			change.Value = &value.Int64
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return changes, nil
+}
+
+// AddTypeRules adds new versions of the wallet types' rules. A version with
+// the same effective time replaces the one already scheduled
+func (r *ruleRepo) AddTypeRules(ctx context.Context, tx *sql.Tx, changes []models.LimitRuleChange) error {
+	const fn = "storage.postgres.AddTypeRules"
+
+	query := `INSERT INTO limit_rules(wallet_type, currency, rule, value, effective_from) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (wallet_type, currency, rule, effective_from) WHERE wallet_type IS NOT NULL
+	DO UPDATE SET value = EXCLUDED.value`
+
+	for _, change := range changes {
+		_, err := tx.ExecContext(ctx, query, change.WalletType, change.Currency, change.Rule, change.Value, change.EffectiveFrom)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+
+	return nil
+}
+
+// ListWalletRules returns all versions of the wallet's own rules, ordered by
+// the time they take effect
+func (r *ruleRepo) ListWalletRules(ctx context.Context, walletID int) ([]models.LimitRuleChange, error) {
+	const fn = "storage.postgres.ListWalletRules"
+
+	query := `SELECT rule, value, effective_from FROM limit_rules
+	WHERE wallet_id = $1
+	ORDER BY effective_from, rule`
+
+	rows, err := r.db.QueryContext(ctx, query, walletID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	var changes []models.LimitRuleChange
+	for rows.Next() {
+		var (
+			change = models.LimitRuleChange{WalletID: walletID}
+			value  sql.NullInt64
+		)
+		if err := rows.Scan(&change.Rule, &value, &change.EffectiveFrom); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		if value.Valid {
+			change.Value = &value.Int64
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return changes, nil
+}
+
+// AddWalletRules adds new versions of the wallets' own rules. A version with
+// the same effective time replaces the one already scheduled
+func (r *ruleRepo) AddWalletRules(ctx context.Context, tx *sql.Tx, changes []models.LimitRuleChange) error {
+	const fn = "storage.postgres.AddWalletRules"
+
+	query := `INSERT INTO limit_rules(wallet_id, rule, value, effective_from) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (wallet_id, rule, effective_from) WHERE wallet_id IS NOT NULL
+	DO UPDATE SET value = EXCLUDED.value`
+
+	for _, change := range changes {
+		_, err := tx.ExecContext(ctx, query, change.WalletID, change.Rule, change.Value, change.EffectiveFrom)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteWalletRule removes all versions of the wallet's own rule, so the rule
+// of its type applies again. It returns the number of removed versions
+func (r *ruleRepo) DeleteWalletRule(ctx context.Context, tx *sql.Tx, walletID int, rule string) (int64, error) {
+	const fn = "storage.postgres.DeleteWalletRule"
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM limit_rules WHERE wallet_id = $1 AND rule = $2", walletID, rule)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return deleted, nil
+}
+
+// SupportsCurrency reports whether the wallet type has a max balance in force
+// in the currency, so its wallets can be kept in it. Only the latest effective
+// version counts, a lifted max balance doesn't
+func (r *ruleRepo) SupportsCurrency(ctx context.Context, tx *sql.Tx, walletType int, currency string) (bool, error) {
+	const fn = "storage.postgres.SupportsCurrency"
+
+	var supported bool
+	query := `SELECT COALESCE((
+		SELECT value IS NOT NULL FROM limit_rules
+		WHERE wallet_type = $1 AND currency = $2 AND rule = 'max_balance' AND effective_from <= NOW()
+		ORDER BY effective_from DESC
+		LIMIT 1
+	), false)`
+
+	err := tx.QueryRowContext(ctx, query, walletType, currency).Scan(&supported)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return supported, nil
+}
@@ -3,18 +3,31 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
 )
 
 type txRepo struct {
-	db *sql.DB
+	db           *sql.DB
+	maxRetries   int
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
 }
 
-func newReplanishmentRepo(db *sql.DB) *txRepo {
+func newReplanishmentRepo(db *sql.DB, maxRetries int, retryBackoff, maxBackoff time.Duration) *txRepo {
 	return &txRepo{
-		db: db,
+		db:           db,
+		maxRetries:   maxRetries,
+		retryBackoff: retryBackoff,
+		maxBackoff:   maxBackoff,
 	}
 }
 
@@ -29,13 +42,174 @@ func (r *txRepo) BeginTx(ctx context.Context) (*sql.Tx, error) {
 	return tx, nil
 }
 
+// RunInTx runs fn inside a serializable transaction and commits it.
+// The whole transaction is retried if it fails because of concurrent
+// access to the same rows
+func (r *txRepo) RunInTx(ctx context.Context, txFn func(tx *sql.Tx) error) error {
+	const fn = "storage.postgres.RunInTx"
+
+	for attempt := 0; ; attempt++ {
+		err := r.runInTx(ctx, txFn)
+		if err == nil {
+			return nil
+		}
+		if !isRetryable(err) || attempt >= r.maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", fn, ctx.Err())
+		case <-time.After(r.retryDelay(attempt)):
+		}
+	}
+}
+
+// retryDelay returns the pause before the retry following the attempt. It
+// doubles with every attempt up to maxBackoff, and a random half of it is
+// taken off, so concurrent retries spread out
+func (r *txRepo) retryDelay(attempt int) time.Duration {
+	backoff := r.maxBackoff
+	// shifting the cap right rather than the backoff left can't overflow
+	if r.retryBackoff <= r.maxBackoff>>attempt {
+		backoff = r.retryBackoff << attempt
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	return backoff - time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func (r *txRepo) runInTx(ctx context.Context, txFn func(tx *sql.Tx) error) error {
+	const fn = "storage.postgres.runInTx"
+
+	tx, err := r.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := txFn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+func isRetryable(err error) bool {
+	code := pqErrorCode(err)
+	return code == pqSerializationFailure || code == pqDeadlockDetected
+}
+
 // PutFunds adds info of the new refill
 func (r *txRepo) PutFunds(ctx context.Context, tx *sql.Tx, payment *models.Payment) (int, error) {
 	const fn = "storage.postgres.PutFunds"
 
 	var id int
-	query := `INSERT INTO transactions(wallet_id, amount) VALUES ($1, $2) RETURNING id`
-	err := tx.QueryRowContext(ctx, query, payment.WalletID, payment.Amount).Scan(&id)
+	query := `INSERT INTO transactions(wallet_id, amount, type, currency)
+	VALUES ($1, $2, $3, (SELECT currency FROM wallets WHERE id = $1)) RETURNING id`
+	err := tx.QueryRowContext(ctx, query, payment.WalletID, payment.Amount, models.TxTypeTopUp).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// Withdraw adds info of the new debit
+func (r *txRepo) Withdraw(ctx context.Context, tx *sql.Tx, payment *models.Payment) (int, error) {
+	const fn = "storage.postgres.Withdraw"
+
+	var id int
+	query := `INSERT INTO transactions(wallet_id, amount, type, currency)
+	VALUES ($1, $2, $3, (SELECT currency FROM wallets WHERE id = $1)) RETURNING id`
+	err := tx.QueryRowContext(ctx, query, payment.WalletID, payment.Amount, models.TxTypeWithdrawal).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// Transfer adds info of the new transfer and both of its legs
+func (r *txRepo) Transfer(ctx context.Context, tx *sql.Tx, transfer *models.Transfer) (int, error) {
+	const fn = "storage.postgres.Transfer"
+
+	var id int
+	query := `INSERT INTO transfers(from_wallet_id, to_wallet_id, amount) VALUES ($1, $2, $3) RETURNING id`
+	err := tx.QueryRowContext(ctx, query, transfer.FromWalletID, transfer.ToWalletID, transfer.Amount).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	query = `INSERT INTO transactions(wallet_id, amount, type, transfer_id, currency)
+	VALUES
+		($1, $3, $4, $6, (SELECT currency FROM wallets WHERE id = $1)),
+		($2, $3, $5, $6, (SELECT currency FROM wallets WHERE id = $2))`
+	_, err = tx.ExecContext(
+		ctx,
+		query,
+		transfer.FromWalletID,
+		transfer.ToWalletID,
+		transfer.Amount,
+		models.TxTypeTransferOut,
+		models.TxTypeTransferIn,
+		id,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// GetForUpdate returns the transaction with its refunded part, locking it
+// until tx ends, so concurrent reversals of the same top-up are serialized
+func (r *txRepo) GetForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.Transaction, error) {
+	const fn = "storage.postgres.GetTransactionForUpdate"
+
+	transaction := &models.Transaction{ID: id}
+	query := `SELECT t.wallet_id, t.type, t.amount, t.created_at,
+		(SELECT COALESCE(SUM(r.amount), 0) FROM transactions r WHERE r.original_id = t.id)
+	FROM transactions t WHERE t.id = $1 FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(
+		&transaction.WalletID,
+		&transaction.Type,
+		&transaction.Amount,
+		&transaction.CreatedAt,
+		&transaction.Reversed,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrTransactionNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return transaction, nil
+}
+
+// Reverse adds info of the refund of the top-up
+func (r *txRepo) Reverse(ctx context.Context, tx *sql.Tx, reversal *models.Reversal) (int, error) {
+	const fn = "storage.postgres.Reverse"
+
+	var id int
+	query := `INSERT INTO transactions(wallet_id, amount, type, original_id, currency)
+	VALUES ($1, $2, $3, $4, (SELECT currency FROM wallets WHERE id = $1)) RETURNING id`
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		reversal.WalletID,
+		reversal.Amount,
+		models.TxTypeReversal,
+		reversal.OriginalID,
+	).Scan(&id)
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", fn, err)
 	}
@@ -54,7 +228,7 @@ func (r *txRepo) GetMonthlyStats(ctx context.Context, statRange *models.WalletSt
 
 	result := &models.WalletStatResult{}
 	query := `SELECT COUNT(amount) AS number, SUM(amount) AS total FROM transactions
-	WHERE wallet_id = $1 AND created_at BETWEEN $2 AND $3`
+	WHERE wallet_id = $1 AND type = $4 AND created_at >= $2 AND created_at < $3`
 
 	err := r.db.QueryRowContext(
 		ctx,
@@ -62,6 +236,7 @@ func (r *txRepo) GetMonthlyStats(ctx context.Context, statRange *models.WalletSt
 		statRange.WalletID,
 		statRange.DateBegin,
 		statRange.DateEnd,
+		models.TxTypeTopUp,
 	).Scan(&number, &amount)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", fn, err)
@@ -71,7 +246,172 @@ func (r *txRepo) GetMonthlyStats(ctx context.Context, statRange *models.WalletSt
 		result.Number = int(number.Int64)
 	}
 	if amount.Valid {
-		result.Amount = int(amount.Int64)
+		result.Amount = money.Amount(amount.Int64)
+	}
+
+	return result, nil
+}
+
+// GetTurnover sums up the wallet's operations since the start of the day and
+// of the month. Incoming turnover is net of refunded top-ups, and only the
+// operations made by the wallet itself are counted
+func (r *txRepo) GetTurnover(ctx context.Context, tx *sql.Tx, walletID int, dayStart, monthStart time.Time) (*models.Turnover, error) {
+	const fn = "storage.postgres.GetTurnover"
+
+	turnover := &models.Turnover{}
+	query := `SELECT
+		COALESCE(SUM(CASE WHEN type = 'reversal' THEN -amount ELSE amount END)
+			FILTER (WHERE type IN ('top_up', 'transfer_in', 'reversal') AND created_at >= $2), 0),
+		COALESCE(SUM(CASE WHEN type = 'reversal' THEN -amount ELSE amount END)
+			FILTER (WHERE type IN ('top_up', 'transfer_in', 'reversal')), 0),
+		COUNT(*) FILTER (WHERE type IN ('top_up', 'withdrawal', 'transfer_out') AND created_at >= $2)
+	FROM transactions
+	WHERE wallet_id = $1 AND created_at >= $3`
+
+	err := tx.QueryRowContext(ctx, query, walletID, dayStart, monthStart).Scan(
+		&turnover.DailyIncoming,
+		&turnover.MonthlyIncoming,
+		&turnover.DailyOperations,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return turnover, nil
+}
+
+// statsIntervals are the lengths of the series buckets
+var statsIntervals = map[string]string{
+	models.StatsByDay:   "1 day",
+	models.StatsByWeek:  "1 week",
+	models.StatsByMonth: "1 month",
+}
+
+// GetStatsSeries calculates refills' stats of every bucket of the range,
+// including the empty ones. Buckets are counted in the local time of the
+// range location, so days stay calendar days across DST changes. Weeks
+// start on Monday
+func (r *txRepo) GetStatsSeries(ctx context.Context, statRange *models.WalletStatsRange) ([]models.WalletStatsBucket, error) {
+	const fn = "storage.postgres.GetStatsSeries"
+
+	query := `SELECT b.bucket AT TIME ZONE $7, COUNT(t.id), COALESCE(SUM(t.amount), 0)
+	FROM generate_series(
+		date_trunc($4, $2::timestamptz AT TIME ZONE $7),
+		($3::timestamptz AT TIME ZONE $7) - interval '1 microsecond',
+		$5::interval
+	) AS b(bucket)
+	LEFT JOIN transactions t ON t.wallet_id = $1 AND t.type = $6
+		AND t.created_at >= GREATEST(b.bucket AT TIME ZONE $7, $2::timestamptz)
+		AND t.created_at < LEAST((b.bucket + $5::interval) AT TIME ZONE $7, $3::timestamptz)
+	GROUP BY b.bucket ORDER BY b.bucket`
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		query,
+		statRange.WalletID,
+		statRange.DateBegin,
+		statRange.DateEnd,
+		statRange.GroupBy,
+		statsIntervals[statRange.GroupBy],
+		models.TxTypeTopUp,
+		statRange.Location.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	result := make([]models.WalletStatsBucket, 0)
+	for rows.Next() {
+		var bucket models.WalletStatsBucket
+		if err := rows.Scan(&bucket.Start, &bucket.Number, &bucket.Amount); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		result = append(result, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return result, nil
+}
+
+// List returns wallet operations matching the filter, newest first. Transfer
+// legs come with the user id of the other wallet and top-ups with their
+// refunded part
+func (r *txRepo) List(ctx context.Context, filter *models.TransactionFilter) ([]models.Transaction, error) {
+	const fn = "storage.postgres.ListTransactions"
+
+	args := []any{filter.WalletID}
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var query strings.Builder
+	query.WriteString(`SELECT tr.id, tr.type, tr.amount, tr.currency, COALESCE(cw.user_id, ''), COALESCE(cw.public_id, ''), tr.transfer_id, tr.original_id,
+		(SELECT COALESCE(SUM(r.amount), 0) FROM transactions r WHERE r.original_id = tr.id), tr.created_at
+	FROM transactions tr
+	LEFT JOIN transfers t ON t.id = tr.transfer_id
+	LEFT JOIN wallets cw ON cw.id = CASE WHEN tr.type = 'transfer_out' THEN t.to_wallet_id ELSE t.from_wallet_id END
+	WHERE tr.wallet_id = $1`)
+
+	if !filter.From.IsZero() {
+		query.WriteString(" AND tr.created_at >= " + arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		query.WriteString(" AND tr.created_at < " + arg(filter.To))
+	}
+	if filter.Type != "" {
+		query.WriteString(" AND tr.type = " + arg(filter.Type))
+	}
+	if filter.MinAmount > 0 {
+		query.WriteString(" AND tr.amount >= " + arg(filter.MinAmount))
+	}
+	if filter.MaxAmount > 0 {
+		query.WriteString(" AND tr.amount <= " + arg(filter.MaxAmount))
+	}
+	if filter.After != nil {
+		query.WriteString(" AND (tr.created_at, tr.id) < (" + arg(filter.After.CreatedAt) + ", " + arg(filter.After.ID) + ")")
+	}
+	query.WriteString(" ORDER BY tr.created_at DESC, tr.id DESC LIMIT " + arg(filter.Limit))
+
+	rows, err := r.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	result := make([]models.Transaction, 0, filter.Limit)
+	for rows.Next() {
+		var (
+			transaction models.Transaction
+			transferID  sql.NullInt64
+			originalID  sql.NullInt64
+		)
+
+		err := rows.Scan(
+			&transaction.ID,
+			&transaction.Type,
+			&transaction.Amount,
+			&transaction.Currency,
+			&transaction.Counterparty,
+			&transaction.CounterpartyWallet,
+			&transferID,
+			&originalID,
+			&transaction.Reversed,
+			&transaction.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		transaction.TransferID = int(transferID.Int64)
+		transaction.OriginalID = int(originalID.Int64)
+
+		result = append(result, transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
 	}
 
 	return result, nil
@@ -0,0 +1,397 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/parviz-yu/digital-wallet/internal/config"
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	"github.com/parviz-yu/digital-wallet/internal/service"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/logger"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
+)
+
+// testDSNEnv names the database the integration tests run against. It must
+// have initdb/init.sql applied, the tests are skipped without it
+const testDSNEnv = "TEST_POSTGRES_DSN"
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"serialization failure", &pq.Error{Code: pqSerializationFailure}, true},
+		{"deadlock", &pq.Error{Code: pqDeadlockDetected}, true},
+		{"wrapped serialization failure", fmt.Errorf("commit: %w", &pq.Error{Code: pqSerializationFailure}), true},
+		{"unique violation", &pq.Error{Code: pqUniqueViolation}, false},
+		{"not a postgres error", customerrors.ErrWalletNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunInTx(t *testing.T) {
+	serialization := &pq.Error{Code: pqSerializationFailure}
+	deadlock := &pq.Error{Code: pqDeadlockDetected}
+
+	tests := []struct {
+		name       string
+		maxRetries int
+		fnErrs     []error // returned by consecutive runs of the function
+		commitErrs []error // returned by consecutive commits
+		wantRuns   int
+		wantErr    error
+	}{
+		{
+			name:       "commits at once",
+			maxRetries: 3,
+			wantRuns:   1,
+		},
+		{
+			name:       "retries serialization failures and deadlocks",
+			maxRetries: 3,
+			fnErrs:     []error{serialization, deadlock},
+			wantRuns:   3,
+		},
+		{
+			name:       "retries failed commits",
+			maxRetries: 3,
+			commitErrs: []error{serialization},
+			wantRuns:   2,
+		},
+		{
+			name:       "returns other errors at once",
+			maxRetries: 3,
+			fnErrs:     []error{customerrors.ErrWalletNotFound},
+			wantRuns:   1,
+			wantErr:    customerrors.ErrWalletNotFound,
+		},
+		{
+			name:       "gives up after max retries",
+			maxRetries: 2,
+			fnErrs:     []error{serialization, serialization, serialization, serialization},
+			wantRuns:   3,
+			wantErr:    serialization,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := sql.OpenDB(&fakeDriver{commitErrs: tt.commitErrs})
+			defer db.Close()
+			repo := newReplanishmentRepo(db, tt.maxRetries, time.Millisecond, time.Millisecond)
+
+			var runs int
+			err := repo.RunInTx(context.Background(), func(tx *sql.Tx) error {
+				runs++
+				if runs <= len(tt.fnErrs) {
+					return tt.fnErrs[runs-1]
+				}
+				return nil
+			})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RunInTx() error = %v, want %v", err, tt.wantErr)
+			}
+			if runs != tt.wantRuns {
+				t.Errorf("RunInTx() ran the function %d times, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name         string
+		retryBackoff time.Duration
+		maxBackoff   time.Duration
+	}{
+		{"backoff below the cap", 10 * time.Millisecond, time.Second},
+		{"backoff at the cap", time.Second, time.Second},
+		{"no backoff", 0, time.Second},
+		{"largest durations", time.Duration(math.MaxInt64), time.Duration(math.MaxInt64)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newReplanishmentRepo(nil, config.MaxTxRetries, tt.retryBackoff, tt.maxBackoff)
+
+			// attempts far past any shift width must neither panic nor overflow
+			for attempt := 0; attempt < 200; attempt++ {
+				delay := repo.retryDelay(attempt)
+				if delay < 0 || delay > tt.maxBackoff {
+					t.Fatalf("retryDelay(%d) = %v, want from 0 to %v", attempt, delay, tt.maxBackoff)
+				}
+				if tt.retryBackoff > 0 && delay < tt.retryBackoff/2 {
+					t.Fatalf("retryDelay(%d) = %v, want at least %v", attempt, delay, tt.retryBackoff/2)
+				}
+			}
+		})
+	}
+}
+
+func TestRunInTxStopsBackoffOnCancel(t *testing.T) {
+	db := sql.OpenDB(&fakeDriver{})
+	defer db.Close()
+	repo := newReplanishmentRepo(db, 3, time.Hour, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var runs int
+	err := repo.RunInTx(ctx, func(tx *sql.Tx) error {
+		runs++
+		return &pq.Error{Code: pqSerializationFailure}
+	})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RunInTx() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if runs != 1 {
+		t.Errorf("RunInTx() ran the function %d times, want 1", runs)
+	}
+}
+
+// TestPutFundsConcurrentMaxBalance tops up one wallet in parallel and checks
+// that the balance never goes above max_balance of its type: the top-ups
+// which fit are applied and the rest are rejected by the rule
+func TestPutFundsConcurrentMaxBalance(t *testing.T) {
+	svc := testService(t)
+	ctx := context.Background()
+
+	const (
+		topUps    = 25
+		amount    = money.Decimal("100")
+		succeeded = 10 // top-ups which fit under the max balance
+	)
+	maxBalance := money.Decimal("1000")
+	owner := testWallet(t, svc, maxBalance)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, topUps)
+	for i := 0; i < topUps; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- svc.PutFunds(ctx, &models.PaymentReq{Owner: owner, Amount: amount})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var applied int
+	for err := range errs {
+		var ruleErr customerrors.ErrRuleViolated
+		switch {
+		case err == nil:
+			applied++
+		case errors.As(err, &ruleErr) && ruleErr.Rule == models.RuleMaxBalance:
+		default:
+			t.Errorf("PutFunds() unexpected error: %v", err)
+		}
+	}
+	if applied != succeeded {
+		t.Errorf("PutFunds() applied %d top-ups, want %d", applied, succeeded)
+	}
+
+	wallet, err := svc.GetWalletBalance(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Balance != maxBalance {
+		t.Errorf("balance = %s, want %s", wallet.Balance, maxBalance)
+	}
+}
+
+// TestPutFundsReplaysCommittedKey retries a top-up whose response was lost
+// after it had committed: the retry gets the stored response and the wallet
+// is topped up once
+func TestPutFundsReplaysCommittedKey(t *testing.T) {
+	svc := testService(t)
+	ctx := context.Background()
+
+	owner := testWallet(t, svc, money.Decimal("1000"))
+	key := func() *models.IdempotencyKey {
+		return &models.IdempotencyKey{
+			Key:         testUUID(t),
+			PartnerID:   owner.PartnerID,
+			UserID:      owner.UserID,
+			RequestHash: "top-up",
+		}
+	}
+	first := key()
+	retry := *first
+
+	err := svc.PutFunds(ctx, &models.PaymentReq{Owner: owner, Amount: "100", IdempotencyKey: first})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.PutFunds(ctx, &models.PaymentReq{Owner: owner, Amount: "100", IdempotencyKey: &retry})
+	var completed customerrors.ErrRequestCompleted
+	if !errors.As(err, &completed) {
+		t.Fatalf("PutFunds() retry error = %v, want %T", err, completed)
+	}
+	if completed.StatusCode != http.StatusOK {
+		t.Errorf("PutFunds() retry replays status %d, want %d", completed.StatusCode, http.StatusOK)
+	}
+
+	wallet, err := svc.GetWalletBalance(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Balance != "100" {
+		t.Errorf("balance = %s, want 100", wallet.Balance)
+	}
+}
+
+// testService connects to the test database, skipping the test without it
+func testService(t *testing.T) service.ServiceI {
+	t.Helper()
+
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := config.Config{
+		IdempotencyTTL: time.Hour,
+		Database: config.Database{
+			TxMaxRetries:   config.MaxTxRetries,
+			TxRetryBackoff: time.Millisecond,
+			TxMaxBackoff:   100 * time.Millisecond,
+		},
+	}
+
+	return service.NewService(cfg, logger.NewLogger(""), newStorage(db, cfg.Database))
+}
+
+// testWallet opens an empty TJS wallet of a new type with the max balance
+func testWallet(t *testing.T, svc service.ServiceI, maxBalance money.Decimal) models.WalletOwner {
+	t.Helper()
+	ctx := context.Background()
+
+	walletType, err := svc.CreateWalletType(ctx, &models.WalletTypeReq{
+		Name:     "test " + testUUID(t),
+		Currency: money.TJS,
+		Limits:   models.WalletTypeLimits{MaxBalance: &maxBalance},
+		// the database clock may lag behind
+		EffectiveFrom: time.Now().Add(-time.Minute),
+		OperatorID:    "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner := models.WalletOwner{PartnerID: 1, UserID: testUUID(t)}
+	_, err = svc.CreateWallet(ctx, &models.CreateWalletReq{
+		Owner:    owner,
+		Type:     walletType.ID,
+		Currency: money.TJS,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return owner
+}
+
+func testUUID(t *testing.T) string {
+	t.Helper()
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// fakeDriver opens connections whose transactions do nothing, so RunInTx can
+// be tested without a database. Commits fail with commitErrs one by one
+type fakeDriver struct {
+	mu         sync.Mutex
+	commitErrs []error
+}
+
+func (d *fakeDriver) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{driver: d}, nil
+}
+
+func (d *fakeDriver) Driver() driver.Driver {
+	return d
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{driver: d}, nil
+}
+
+func (d *fakeDriver) commit() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.commitErrs) == 0 {
+		return nil
+	}
+	err := d.commitErrs[0]
+	d.commitErrs = d.commitErrs[1:]
+
+	return err
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver doesn't run queries")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return &fakeTx{driver: c.driver}, nil
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return c.Begin()
+}
+
+type fakeTx struct {
+	driver *fakeDriver
+}
+
+func (t *fakeTx) Commit() error {
+	return t.driver.commit()
+}
+
+func (t *fakeTx) Rollback() error {
+	return nil
+}
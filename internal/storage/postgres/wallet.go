@@ -8,6 +8,7 @@ import (
 
 	"github.com/parviz-yu/digital-wallet/internal/models"
 	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
 )
 
 type walletRepo struct {
@@ -20,14 +21,18 @@ func newWalletRepo(db *sql.DB) *walletRepo {
 	}
 }
 
+// ownerCondition selects the wallet of the owner by its public id, or the
+// owner's default wallet if the id is empty
+const ownerCondition = "partner_id = $1 AND user_id = $2 AND (public_id = $3 OR ($3 = '' AND is_default))"
+
 // GetWallet return wallet's id if wallet exists
-func (r *walletRepo) GetWallet(ctx context.Context, userID string) (int, error) {
+func (r *walletRepo) GetWallet(ctx context.Context, owner models.WalletOwner) (int, error) {
 	const fn = "storage.postgres.GetWallet"
 
 	var id int
-	query := "SELECT id FROM wallets WHERE user_id = $1"
+	query := "SELECT id FROM wallets WHERE " + ownerCondition
 
-	err := r.db.QueryRowContext(ctx, query, userID).Scan(&id)
+	err := r.db.QueryRowContext(ctx, query, owner.PartnerID, owner.UserID, owner.WalletID).Scan(&id)
 	if errors.Is(err, sql.ErrNoRows) {
 		return 0, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletNotFound)
 	}
@@ -38,14 +43,122 @@ func (r *walletRepo) GetWallet(ctx context.Context, userID string) (int, error)
 	return id, nil
 }
 
-// CheckBalance return wallet's balance and wallet's type
-func (r *walletRepo) CheckBalance(ctx context.Context, userID string) (*models.Wallet, error) {
+// CreateWallet opens a new empty wallet for the user. The user's first wallet
+// becomes the default one. If another request makes the first wallet at the
+// same time, this one is opened as a regular wallet instead
+func (r *walletRepo) CreateWallet(ctx context.Context, tx *sql.Tx, wallet *models.Wallet) (int, error) {
+	const fn = "storage.postgres.CreateWallet"
+
+	var id int
+	query := `INSERT INTO wallets(partner_id, user_id, type, currency, name, is_default)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6 AND NOT EXISTS (
+		SELECT 1 FROM wallets WHERE partner_id = $1 AND user_id = $2 AND is_default
+	))
+	ON CONFLICT (partner_id, user_id) WHERE is_default DO NOTHING
+	RETURNING id, public_id, is_default`
+
+	err := tx.QueryRowContext(ctx, query, wallet.PartnerID, wallet.UserID, wallet.Type, wallet.Currency, wallet.Name, true).
+		Scan(&id, &wallet.PublicID, &wallet.IsDefault)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the default wallet was made after the check, a regular one can't conflict
+		err = tx.QueryRowContext(ctx, query, wallet.PartnerID, wallet.UserID, wallet.Type, wallet.Currency, wallet.Name, false).
+			Scan(&id, &wallet.PublicID, &wallet.IsDefault)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+// CheckBalance return wallet's balance, type and status
+func (r *walletRepo) CheckBalance(ctx context.Context, owner models.WalletOwner) (*models.Wallet, error) {
 	const fn = "storage.postgres.CheckBalance"
 
-	wllt := &models.Wallet{}
-	query := "SELECT id, balance, type FROM wallets WHERE user_id = $1"
+	wllt := &models.Wallet{PartnerID: owner.PartnerID, UserID: owner.UserID}
+	query := "SELECT id, public_id, balance, currency, type, status FROM wallets WHERE " + ownerCondition
+
+	err := r.db.QueryRowContext(ctx, query, owner.PartnerID, owner.UserID, owner.WalletID).
+		Scan(&wllt.ID, &wllt.PublicID, &wllt.Balance, &wllt.Currency, &wllt.Type, &wllt.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return wllt, nil
+}
+
+// GetForUpdate return wallet's balance, type and status, locking the row
+// until tx ends
+func (r *walletRepo) GetForUpdate(ctx context.Context, tx *sql.Tx, owner models.WalletOwner) (*models.Wallet, error) {
+	const fn = "storage.postgres.GetForUpdate"
+
+	wllt := &models.Wallet{PartnerID: owner.PartnerID, UserID: owner.UserID}
+	query := "SELECT id, public_id, balance, currency, type, status FROM wallets WHERE " + ownerCondition + " FOR UPDATE"
+
+	err := tx.QueryRowContext(ctx, query, owner.PartnerID, owner.UserID, owner.WalletID).
+		Scan(&wllt.ID, &wllt.PublicID, &wllt.Balance, &wllt.Currency, &wllt.Type, &wllt.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return wllt, nil
+}
+
+// GetByIDForUpdate is GetForUpdate for wallets already known by id
+func (r *walletRepo) GetByIDForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.Wallet, error) {
+	const fn = "storage.postgres.GetByIDForUpdate"
+
+	wllt := &models.Wallet{ID: id}
+	query := "SELECT public_id, partner_id, user_id, balance, currency, type, status FROM wallets WHERE id = $1 FOR UPDATE"
+
+	err := tx.QueryRowContext(ctx, query, id).
+		Scan(&wllt.PublicID, &wllt.PartnerID, &wllt.UserID, &wllt.Balance, &wllt.Currency, &wllt.Type, &wllt.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return wllt, nil
+}
+
+// GetByPublicID returns the wallet addressed by its public id regardless of
+// the owner
+func (r *walletRepo) GetByPublicID(ctx context.Context, publicID string) (*models.Wallet, error) {
+	const fn = "storage.postgres.GetByPublicID"
+
+	wllt := &models.Wallet{PublicID: publicID}
+	query := "SELECT id, partner_id, user_id, balance, currency, type, status FROM wallets WHERE public_id = $1"
+
+	err := r.db.QueryRowContext(ctx, query, publicID).
+		Scan(&wllt.ID, &wllt.PartnerID, &wllt.UserID, &wllt.Balance, &wllt.Currency, &wllt.Type, &wllt.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return wllt, nil
+}
+
+// GetByPublicIDForUpdate is GetForUpdate for wallets addressed by their
+// public id regardless of the owner
+func (r *walletRepo) GetByPublicIDForUpdate(ctx context.Context, tx *sql.Tx, publicID string) (*models.Wallet, error) {
+	const fn = "storage.postgres.GetByPublicIDForUpdate"
+
+	wllt := &models.Wallet{PublicID: publicID}
+	query := "SELECT id, partner_id, user_id, balance, currency, type, status FROM wallets WHERE public_id = $1 FOR UPDATE"
 
-	err := r.db.QueryRowContext(ctx, query, userID).Scan(&wllt.ID, &wllt.Balance, &wllt.Type)
+	err := tx.QueryRowContext(ctx, query, publicID).
+		Scan(&wllt.ID, &wllt.PartnerID, &wllt.UserID, &wllt.Balance, &wllt.Currency, &wllt.Type, &wllt.Status)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletNotFound)
 	}
@@ -56,6 +169,93 @@ func (r *walletRepo) CheckBalance(ctx context.Context, userID string) (*models.W
 	return wllt, nil
 }
 
+// List returns all wallets of the user, the default one first
+func (r *walletRepo) List(ctx context.Context, partnerID int, userID string) ([]models.Wallet, error) {
+	const fn = "storage.postgres.ListWallets"
+
+	query := `SELECT w.id, w.public_id, COALESCE(w.name, ''), w.is_default, w.balance, w.currency, w.type, l.name, w.status
+	FROM wallets w
+	JOIN limits l ON l.id = w.type
+	WHERE w.partner_id = $1 AND w.user_id = $2
+	ORDER BY w.is_default DESC, w.id`
+
+	rows, err := r.db.QueryContext(ctx, query, partnerID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	var wallets []models.Wallet
+	for rows.Next() {
+		wllt := models.Wallet{PartnerID: partnerID, UserID: userID}
+		err := rows.Scan(
+			&wllt.ID,
+			&wllt.PublicID,
+			&wllt.Name,
+			&wllt.IsDefault,
+			&wllt.Balance,
+			&wllt.Currency,
+			&wllt.Type,
+			&wllt.TypeName,
+			&wllt.Status,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		wallets = append(wallets, wllt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return wallets, nil
+}
+
+// SetDefault makes the wallet the default one of its user
+func (r *walletRepo) SetDefault(ctx context.Context, tx *sql.Tx, wallet *models.Wallet) error {
+	const fn = "storage.postgres.SetDefault"
+
+	query := "UPDATE wallets SET is_default = FALSE WHERE partner_id = $1 AND user_id = $2 AND is_default"
+	if _, err := tx.ExecContext(ctx, query, wallet.PartnerID, wallet.UserID); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	query = "UPDATE wallets SET is_default = TRUE WHERE id = $1"
+	if _, err := tx.ExecContext(ctx, query, wallet.ID); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// UpdateStatus moves the wallet to the new status and remembers the reason
+func (r *walletRepo) UpdateStatus(ctx context.Context, tx *sql.Tx, change *models.WalletStatusChange) error {
+	const fn = "storage.postgres.UpdateStatus"
+
+	query := "UPDATE wallets SET status = $2, status_reason = $3, status_changed_at = NOW() WHERE id = $1"
+	_, err := tx.ExecContext(ctx, query, change.WalletID, change.Status, change.Reason)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// AddPayout records where the balance of the closed wallet was paid out to
+func (r *walletRepo) AddPayout(ctx context.Context, tx *sql.Tx, payout *models.Payout) (int, error) {
+	const fn = "storage.postgres.AddPayout"
+
+	var id int
+	query := "INSERT INTO payouts(wallet_id, transaction_id, amount, destination) VALUES ($1, $2, $3, $4) RETURNING id"
+
+	err := tx.QueryRowContext(ctx, query, payout.WalletID, payout.TransactionID, payout.Amount, payout.Destination).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
 // UpdateBalance updates wallet's balance
 func (r *walletRepo) UpdateBalance(ctx context.Context, tx *sql.Tx, payment *models.Payment) error {
 	const fn = "storage.postgres.UpdateBalance"
@@ -69,15 +269,51 @@ func (r *walletRepo) UpdateBalance(ctx context.Context, tx *sql.Tx, payment *mod
 	return nil
 }
 
-func (r *walletRepo) GetLimit(ctx context.Context, id int) (*models.Limit, error) {
-	const fn = "storage.postgres.GetLimits"
+// DecreaseBalance subtracts payment's amount from wallet's balance
+func (r *walletRepo) DecreaseBalance(ctx context.Context, tx *sql.Tx, payment *models.Payment) error {
+	const fn = "storage.postgres.DecreaseBalance"
 
-	limit := &models.Limit{}
-	query := `SELECT name, max_amount FROM limits WHERE id = $1`
+	query := "UPDATE wallets SET balance = balance - $2 WHERE id = $1"
+	_, err := tx.ExecContext(ctx, query, payment.WalletID, payment.Amount)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
 
-	if err := r.db.QueryRowContext(ctx, query, id).Scan(&limit.Name, &limit.MaxAmount); err != nil {
-		return nil, fmt.Errorf("%s: %w", fn, err)
+	return nil
+}
+
+// UpdateType changes wallet's type, and so the limit applied to it
+func (r *walletRepo) UpdateType(ctx context.Context, tx *sql.Tx, walletID, walletType int) error {
+	const fn = "storage.postgres.UpdateType"
+
+	query := "UPDATE wallets SET type = $2 WHERE id = $1"
+	_, err := tx.ExecContext(ctx, query, walletID, walletType)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// CountAboveBalance counts wallets of the type in the currency holding more
+// than the balance. Wallets with their own max balance in force aren't
+// counted, while those whose own max balance was lifted are
+func (r *walletRepo) CountAboveBalance(ctx context.Context, tx *sql.Tx, walletType int, currency string, balance money.Amount) (int, error) {
+	const fn = "storage.postgres.CountAboveBalance"
+
+	var count int
+	query := `SELECT COUNT(*) FROM wallets w
+	WHERE w.type = $1 AND w.currency = $2 AND w.balance > $3 AND NOT COALESCE((
+		SELECT r.value IS NOT NULL FROM limit_rules r
+		WHERE r.wallet_id = w.id AND r.rule = 'max_balance' AND r.effective_from <= NOW()
+		ORDER BY r.effective_from DESC
+		LIMIT 1
+	), false)`
+
+	err := tx.QueryRowContext(ctx, query, walletType, currency, balance).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
 	}
 
-	return limit, nil
+	return count, nil
 }
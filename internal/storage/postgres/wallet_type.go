@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/internal/models"
+	customerrors "github.com/parviz-yu/digital-wallet/pkg/custom-errors"
+)
+
+type walletTypeRepo struct {
+	db *sql.DB
+}
+
+func newWalletTypeRepo(db *sql.DB) *walletTypeRepo {
+	return &walletTypeRepo{
+		db: db,
+	}
+}
+
+func (r *walletTypeRepo) List(ctx context.Context) ([]models.WalletType, error) {
+	const fn = "storage.postgres.ListWalletTypes"
+
+	query := "SELECT id, name, created_at FROM limits ORDER BY id"
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer rows.Close()
+
+	var walletTypes []models.WalletType
+	for rows.Next() {
+		var walletType models.WalletType
+		if err := rows.Scan(&walletType.ID, &walletType.Name, &walletType.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		walletTypes = append(walletTypes, walletType)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return walletTypes, nil
+}
+
+func (r *walletTypeRepo) Get(ctx context.Context, id int) (*models.WalletType, error) {
+	const fn = "storage.postgres.GetWalletType"
+
+	walletType := &models.WalletType{}
+	query := "SELECT id, name, created_at FROM limits WHERE id = $1"
+
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&walletType.ID, &walletType.Name, &walletType.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletTypeNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return walletType, nil
+}
+
+// GetForUpdate locks the wallet type, so its limits are changed one at a time
+func (r *walletTypeRepo) GetForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.WalletType, error) {
+	const fn = "storage.postgres.GetWalletTypeForUpdate"
+
+	walletType := &models.WalletType{}
+	query := "SELECT id, name, created_at FROM limits WHERE id = $1 FOR UPDATE"
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(&walletType.ID, &walletType.Name, &walletType.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletTypeNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return walletType, nil
+}
+
+func (r *walletTypeRepo) Create(ctx context.Context, tx *sql.Tx, name string) (int, error) {
+	const fn = "storage.postgres.CreateWalletType"
+
+	var id int
+	query := "INSERT INTO limits(name) VALUES ($1) RETURNING id"
+
+	err := tx.QueryRowContext(ctx, query, name).Scan(&id)
+	if pqErrorCode(err) == pqUniqueViolation {
+		return 0, fmt.Errorf("%s: %w", fn, customerrors.ErrWalletTypeExists)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return id, nil
+}
+
+func (r *walletTypeRepo) Rename(ctx context.Context, tx *sql.Tx, id int, name string) error {
+	const fn = "storage.postgres.RenameWalletType"
+
+	query := "UPDATE limits SET name = $2 WHERE id = $1"
+
+	_, err := tx.ExecContext(ctx, query, id, name)
+	if pqErrorCode(err) == pqUniqueViolation {
+		return fmt.Errorf("%s: %w", fn, customerrors.ErrWalletTypeExists)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return nil
+}
@@ -3,25 +3,120 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/parviz-yu/digital-wallet/internal/models"
+	"github.com/parviz-yu/digital-wallet/pkg/money"
 )
 
 type StorageI interface {
 	CloseDB()
 	Wallet() WalletRepoI
 	Transaction() TxRepoI
+	Idempotency() IdempotencyRepoI
+	Audit() AuditRepoI
+	Identification() IdentificationRepoI
+	Partner() PartnerRepoI
+	Nonce() NonceRepoI
+	Ledger() LedgerRepoI
+	Reconciliation() ReconciliationRepoI
+	Rule() RuleRepoI
+	WalletType() WalletTypeRepoI
 }
 
 type WalletRepoI interface {
-	GetWallet(ctx context.Context, userID string) (int, error)
-	CheckBalance(ctx context.Context, userID string) (*models.Wallet, error)
+	GetWallet(ctx context.Context, owner models.WalletOwner) (int, error)
+	CreateWallet(ctx context.Context, tx *sql.Tx, wallet *models.Wallet) (int, error)
+	CheckBalance(ctx context.Context, owner models.WalletOwner) (*models.Wallet, error)
+	GetForUpdate(ctx context.Context, tx *sql.Tx, owner models.WalletOwner) (*models.Wallet, error)
+	GetByIDForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.Wallet, error)
+	GetByPublicID(ctx context.Context, publicID string) (*models.Wallet, error)
+	GetByPublicIDForUpdate(ctx context.Context, tx *sql.Tx, publicID string) (*models.Wallet, error)
+	List(ctx context.Context, partnerID int, userID string) ([]models.Wallet, error)
+	SetDefault(ctx context.Context, tx *sql.Tx, wallet *models.Wallet) error
 	UpdateBalance(ctx context.Context, tx *sql.Tx, payment *models.Payment) error
-	GetLimit(ctx context.Context, id int) (*models.Limit, error)
+	DecreaseBalance(ctx context.Context, tx *sql.Tx, payment *models.Payment) error
+	UpdateType(ctx context.Context, tx *sql.Tx, walletID, walletType int) error
+	UpdateStatus(ctx context.Context, tx *sql.Tx, change *models.WalletStatusChange) error
+	AddPayout(ctx context.Context, tx *sql.Tx, payout *models.Payout) (int, error)
+	CountAboveBalance(ctx context.Context, tx *sql.Tx, walletType int, currency string, balance money.Amount) (int, error)
 }
 
 type TxRepoI interface {
 	BeginTx(ctx context.Context) (*sql.Tx, error)
+	RunInTx(ctx context.Context, fn func(tx *sql.Tx) error) error
 	GetMonthlyStats(ctx context.Context, statRange *models.WalletStatsRange) (*models.WalletStatResult, error)
+	GetStatsSeries(ctx context.Context, statRange *models.WalletStatsRange) ([]models.WalletStatsBucket, error)
+	List(ctx context.Context, filter *models.TransactionFilter) ([]models.Transaction, error)
 	PutFunds(ctx context.Context, tx *sql.Tx, payment *models.Payment) (int, error)
+	Withdraw(ctx context.Context, tx *sql.Tx, payment *models.Payment) (int, error)
+	Transfer(ctx context.Context, tx *sql.Tx, transfer *models.Transfer) (int, error)
+	GetForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.Transaction, error)
+	Reverse(ctx context.Context, tx *sql.Tx, reversal *models.Reversal) (int, error)
+	GetTurnover(ctx context.Context, tx *sql.Tx, walletID int, dayStart, monthStart time.Time) (*models.Turnover, error)
+}
+
+type IdempotencyRepoI interface {
+	Get(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	Reserve(ctx context.Context, tx *sql.Tx, key *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	Save(ctx context.Context, tx *sql.Tx, key *models.IdempotencyKey) error
+	SaveRejected(ctx context.Context, key *models.IdempotencyKey) error
+}
+
+type AuditRepoI interface {
+	Add(ctx context.Context, tx *sql.Tx, record *models.AuditRecord) error
+}
+
+type IdentificationRepoI interface {
+	Create(ctx context.Context, tx *sql.Tx, identification *models.Identification) (int, error)
+	GetLatest(ctx context.Context, walletID int) (*models.Identification, error)
+	GetForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.Identification, error)
+	List(ctx context.Context, status string) ([]models.Identification, error)
+	Review(ctx context.Context, tx *sql.Tx, identification *models.Identification) error
+}
+
+type PartnerRepoI interface {
+	GetPartner(ctx context.Context, id int) (*models.Partner, error)
+	GetActiveKeys(ctx context.Context, partnerID int) ([]models.PartnerKey, error)
+	ListKeys(ctx context.Context, partnerID int) ([]models.PartnerKey, error)
+	CreateKey(ctx context.Context, tx *sql.Tx, key *models.PartnerKey) (int, error)
+	RevokeKey(ctx context.Context, tx *sql.Tx, partnerID int, keyID string) error
+}
+
+type NonceRepoI interface {
+	Use(ctx context.Context, partnerID int, nonce string, expiresAt time.Time) error
+	Purge(ctx context.Context) (int64, error)
+}
+
+type LedgerRepoI interface {
+	CreateWalletAccount(ctx context.Context, tx *sql.Tx, wallet *models.Wallet) (int, error)
+	GetWalletAccount(ctx context.Context, tx *sql.Tx, walletID int) (*models.Account, error)
+	GetSettlementAccount(ctx context.Context, tx *sql.Tx, partnerID int, currency string) (int, error)
+	GetSystemAccount(ctx context.Context, tx *sql.Tx, accountType, currency string) (int, error)
+	OpenCurrencyAccounts(ctx context.Context, tx *sql.Tx, currency string) error
+	Post(ctx context.Context, tx *sql.Tx, entry *models.JournalEntry) (int, error)
+	Check(ctx context.Context) (*models.LedgerCheck, error)
+}
+
+type ReconciliationRepoI interface {
+	FindDrifts(ctx context.Context) (int, []models.BalanceDrift, error)
+	SaveRun(ctx context.Context, run *models.ReconciliationRun) (int, error)
+}
+
+type RuleRepoI interface {
+	GetWalletRules(ctx context.Context, tx *sql.Tx, wallet *models.Wallet) ([]models.LimitRule, error)
+	ListTypeRules(ctx context.Context) ([]models.LimitRuleChange, error)
+	AddTypeRules(ctx context.Context, tx *sql.Tx, changes []models.LimitRuleChange) error
+	ListWalletRules(ctx context.Context, walletID int) ([]models.LimitRuleChange, error)
+	AddWalletRules(ctx context.Context, tx *sql.Tx, changes []models.LimitRuleChange) error
+	DeleteWalletRule(ctx context.Context, tx *sql.Tx, walletID int, rule string) (int64, error)
+	SupportsCurrency(ctx context.Context, tx *sql.Tx, walletType int, currency string) (bool, error)
+}
+
+type WalletTypeRepoI interface {
+	List(ctx context.Context) ([]models.WalletType, error)
+	Get(ctx context.Context, id int) (*models.WalletType, error)
+	GetForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.WalletType, error)
+	Create(ctx context.Context, tx *sql.Tx, name string) (int, error)
+	Rename(ctx context.Context, tx *sql.Tx, id int, name string) error
 }
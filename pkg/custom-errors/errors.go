@@ -3,17 +3,95 @@ package customerrors
 import (
 	"errors"
 	"fmt"
+
+	"github.com/parviz-yu/digital-wallet/pkg/money"
 )
 
 var (
-	ErrWalletNotFound = errors.New("wallet not found")
+	ErrPartnerNotFound     = errors.New("partner not found")
+	ErrPartnerKeyNotFound  = errors.New("partner key not found")
+	ErrPartnerKeyExists    = errors.New("partner key already exists")
+	ErrNonceReused         = errors.New("nonce already used")
+	ErrStaleRequest        = errors.New("request timestamp is outside the allowed window")
+	ErrWalletNotFound      = errors.New("wallet not found")
+	ErrWalletTypeNotFound  = errors.New("wallet type not found")
+	ErrWalletTypeExists    = errors.New("wallet type already exists")
+	ErrWalletLimitNotFound = errors.New("wallet has no own limit of this rule")
+	ErrSelfTransfer        = errors.New("sender and receiver wallets are the same")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrInvalidAmountRange  = errors.New("min_amount is above max_amount")
+	ErrInvalidStatsPeriod  = errors.New("stats period must be from 1 microsecond to 366 days long")
+	ErrAccountNotFound     = errors.New("ledger account not found")
+	ErrUnbalancedEntry     = errors.New("journal entry postings don't sum to zero")
+
+	ErrCurrencyMismatch     = errors.New("currency doesn't match the wallet's currency")
+	ErrCurrencyNotSupported = errors.New("wallet type has no limits in this currency")
+	ErrAmountTooSmall       = errors.New("amount must be at least 1 unit of the currency")
+
+	ErrWalletFrozenCredit    = errors.New("wallet is frozen for incoming payments")
+	ErrWalletFrozenDebit     = errors.New("wallet is frozen for outgoing payments")
+	ErrWalletBlocked         = errors.New("wallet is blocked")
+	ErrWalletClosed          = errors.New("wallet is closed")
+	ErrReceiverNotAccepting  = errors.New("receiver wallet doesn't accept payments")
+	ErrWalletStatusUnchanged = errors.New("wallet already has this status")
+	ErrBalanceNotPaidOut     = errors.New("wallet balance must be zero or paid out to payout_destination")
+
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrNotReversible       = errors.New("only top-ups can be reversed")
+	ErrAlreadyReversed     = errors.New("transaction is already fully reversed")
+	ErrReversalTooLarge    = errors.New("reversal amount exceeds the rest of the top-up")
+
+	ErrAlreadyIdentified        = errors.New("wallet is already identified")
+	ErrIdentificationPending    = errors.New("identification request is already pending")
+	ErrIdentificationNotFound   = errors.New("identification request not found")
+	ErrIdentificationNotPending = errors.New("identification request is already reviewed")
+
+	ErrIdempotencyKeyReused = errors.New("idempotency key already used for a different request")
+	ErrRequestInProgress    = errors.New("request with this idempotency key is still in progress")
 )
 
-type ErrLimitExceeded struct {
-	WalletType string
-	MaxAmount  int
+// ErrRuleViolated names the limit rule of the wallet the operation breaks
+type ErrRuleViolated struct {
+	Rule       string
+	Limit      int64
+	Currency   string
+	Operations bool // Limit is a number of operations rather than an amount
+}
+
+func (e ErrRuleViolated) Error() string {
+	if e.Operations {
+		return fmt.Sprintf("limit rule %s violated, limit %d operations", e.Rule, e.Limit)
+	}
+	return fmt.Sprintf("limit rule %s violated, limit %s", e.Rule, money.Money{Amount: money.Amount(e.Limit), Currency: e.Currency})
+}
+
+// ErrBalancesAboveCap is returned when the new max balance of the wallet
+// type is below balances of its wallets
+type ErrBalancesAboveCap struct {
+	Wallets int
+}
+
+func (e ErrBalancesAboveCap) Error() string {
+	return fmt.Sprintf("%d wallets hold more than the new max balance, set force to apply it", e.Wallets)
+}
+
+// ErrRequestCompleted carries the stored response of the request already
+// made with the idempotency key, so its retry gets the same response
+type ErrRequestCompleted struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e ErrRequestCompleted) Error() string {
+	return "request with this idempotency key is already completed"
+}
+
+type ErrInsufficientFunds struct {
+	Balance  money.Amount
+	Amount   money.Amount
+	Currency string
 }
 
-func (e ErrLimitExceeded) Error() string {
-	return fmt.Sprintf("limit exceeded %d TJS", e.MaxAmount/100)
+func (e ErrInsufficientFunds) Error() string {
+	return fmt.Sprintf("insufficient funds, balance %s", money.Money{Amount: e.Balance, Currency: e.Currency})
 }
@@ -0,0 +1,212 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TJS is the currency of wallets opened without one
+const TJS = "TJS"
+
+var (
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrTooPrecise      = errors.New("amount has more decimal places than its currency allows")
+	ErrUnknownCurrency = errors.New("unknown currency")
+)
+
+// Amount is a sum of money in the smallest unit of its currency, e.g. dirams
+// for TJS or cents for USD
+type Amount int64
+
+// Currency is an ISO 4217 currency. Exponent is the number of decimal places
+// of its minor unit: 2 for TJS, 0 for JPY, 3 for KWD
+type Currency struct {
+	Code     string
+	Exponent int
+}
+
+// currencies are the ones wallets can be kept in
+var currencies = map[string]Currency{
+	"TJS": {Code: "TJS", Exponent: 2},
+	"USD": {Code: "USD", Exponent: 2},
+	"EUR": {Code: "EUR", Exponent: 2},
+	"RUB": {Code: "RUB", Exponent: 2},
+	"KZT": {Code: "KZT", Exponent: 2},
+	"UZS": {Code: "UZS", Exponent: 2},
+	"CNY": {Code: "CNY", Exponent: 2},
+	"JPY": {Code: "JPY", Exponent: 0},
+	"KWD": {Code: "KWD", Exponent: 3},
+}
+
+// LookupCurrency returns the currency by its ISO 4217 code
+func LookupCurrency(code string) (Currency, error) {
+	currency, ok := currencies[code]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+
+	return currency, nil
+}
+
+// Unit is one major unit of the currency in minor units, e.g. 100 for TJS
+func (c Currency) Unit() Amount {
+	unit := Amount(1)
+	for i := 0; i < c.Exponent; i++ {
+		unit *= 10
+	}
+
+	return unit
+}
+
+// Parse parses a decimal string like "100", "-5.5" or "0.29" in major units
+// into an Amount. Exponents and more decimal places than the currency has
+// are rejected
+func (c Currency) Parse(s string) (Amount, error) {
+	var negative bool
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	}
+
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if !isDigits(whole) || (hasFrac && !isDigits(frac)) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(frac) > c.Exponent {
+		return 0, fmt.Errorf("%w: %s has %d", ErrTooPrecise, c.Code, c.Exponent)
+	}
+
+	frac += strings.Repeat("0", c.Exponent-len(frac))
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	if negative {
+		minor = -minor
+	}
+
+	return Amount(minor), nil
+}
+
+// Format formats the amount in major units without trailing zeros, e.g.
+// 70065 of TJS is "700.65", 10050 is "100.5" and 10000 is "100"
+func (c Currency) Format(a Amount) Decimal {
+	minor := int64(a)
+
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	unit := int64(c.Unit())
+	whole := strconv.FormatInt(minor/unit, 10)
+	if c.Exponent == 0 {
+		return Decimal(sign + whole)
+	}
+
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", c.Exponent, minor%unit), "0")
+	if frac == "" {
+		return Decimal(sign + whole)
+	}
+
+	return Decimal(sign + whole + "." + frac)
+}
+
+// Money is an amount together with its ISO 4217 currency code
+type Money struct {
+	Amount   Amount
+	Currency string
+}
+
+func (m Money) String() string {
+	currency, err := LookupCurrency(m.Currency)
+	if err != nil {
+		return strconv.FormatInt(int64(m.Amount), 10) + " " + m.Currency
+	}
+
+	return string(currency.Format(m.Amount)) + " " + m.Currency
+}
+
+// Decimal is an amount in major units as written in requests and responses,
+// e.g. "100.5". It's turned into an Amount by the currency it's given in
+type Decimal string
+
+// Amount converts the decimal into minor units of the currency
+func (d Decimal) Amount(currency Currency) (Amount, error) {
+	return currency.Parse(string(d))
+}
+
+// Positive reports whether the decimal is above zero
+func (d Decimal) Positive() bool {
+	if strings.HasPrefix(string(d), "-") {
+		return false
+	}
+
+	return strings.Trim(string(d), "0.") != ""
+}
+
+// MarshalJSON encodes the decimal as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("0"), nil
+	}
+
+	return []byte(d), nil
+}
+
+// UnmarshalJSON accepts both JSON numbers and strings, e.g. 100.5 or "100.5"
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, s)
+		}
+		s = unquoted
+	}
+
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+
+	*d = parsed
+	return nil
+}
+
+// ParseDecimal checks that s is an optionally signed number with an optional
+// fraction and no exponent, e.g. "100" or "-0.5"
+func ParseDecimal(s string) (Decimal, error) {
+	if !isDecimal(s) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	return Decimal(s), nil
+}
+
+// isDecimal reports whether s is an optionally signed number with an
+// optional fraction and no exponent
+func isDecimal(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	whole, frac, hasFrac := strings.Cut(s, ".")
+
+	return isDigits(whole) && (!hasFrac || isDigits(frac))
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
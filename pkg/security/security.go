@@ -1,19 +1,131 @@
 package security
 
 import (
+	"bytes"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
 )
 
-func generateSignature(secretToken string, payloadBody []byte) string {
-	mac := hmac.New(sha1.New, []byte(secretToken))
+// Algorithm is a name of the signature algorithm, as sent in X-Digest-Alg
+type Algorithm string
+
+const (
+	HMACSHA1   Algorithm = "hmac-sha1"
+	HMACSHA256 Algorithm = "hmac-sha256"
+	HMACSHA512 Algorithm = "hmac-sha512"
+)
+
+// secretLength is the number of random bytes in generated secret keys
+const secretLength = 32
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+
+var algorithms = map[Algorithm]func() hash.Hash{
+	HMACSHA1:   sha1.New,
+	HMACSHA256: sha256.New,
+	HMACSHA512: sha512.New,
+}
+
+// ParseAlgorithm returns the algorithm by its case-insensitive name
+func ParseAlgorithm(name string) (Algorithm, error) {
+	alg := Algorithm(strings.ToLower(name))
+	if _, ok := algorithms[alg]; !ok {
+		return "", ErrUnsupportedAlgorithm
+	}
+
+	return alg, nil
+}
+
+func generateSignature(alg Algorithm, secretToken string, payloadBody []byte) []byte {
+	mac := hmac.New(algorithms[alg], []byte(secretToken))
 	mac.Write(payloadBody)
-	expectedMAC := mac.Sum(nil)
-	return base64.StdEncoding.EncodeToString(expectedMAC)
+	return mac.Sum(nil)
+}
+
+// Sign returns the signature of the payload encoded in base64
+func Sign(alg Algorithm, secretToken string, payloadBody []byte) string {
+	return base64.StdEncoding.EncodeToString(generateSignature(alg, secretToken, payloadBody))
 }
 
-func VerifyBody(secretToken string, payloadBody []byte, toCompareWith string) bool {
-	signature := generateSignature(secretToken, payloadBody)
-	return signature == toCompareWith
+// Verify checks the signature of the payload given in hex or base64. The
+// signatures are compared in constant time
+func Verify(alg Algorithm, secretToken string, payloadBody []byte, toCompareWith string) bool {
+	if _, ok := algorithms[alg]; !ok {
+		return false
+	}
+
+	expected := generateSignature(alg, secretToken, payloadBody)
+	signature, ok := decodeSignature(toCompareWith, len(expected))
+	if !ok {
+		return false
+	}
+
+	return hmac.Equal(expected, signature)
+}
+
+// CanonicalRequest joins the signed parts of a request, one per line with
+// the body last, so a signature can't be reused for another method, path,
+// user or moment
+func CanonicalRequest(method, path, userID, timestamp, nonce string, body []byte) []byte {
+	var canonical bytes.Buffer
+	for _, part := range []string{method, path, userID, timestamp, nonce} {
+		canonical.WriteString(part)
+		canonical.WriteByte('\n')
+	}
+	canonical.Write(body)
+
+	return canonical.Bytes()
+}
+
+// CanonicalResponse joins the nonce of the request with the response body,
+// so a response can't be passed off as the reply to another request
+func CanonicalResponse(nonce string, body []byte) []byte {
+	var canonical bytes.Buffer
+	canonical.WriteString(nonce)
+	canonical.WriteByte('\n')
+	canonical.Write(body)
+
+	return canonical.Bytes()
+}
+
+// VerifyResponse checks the X-Digest header of the response to the request
+// sent with the nonce. Partners call it with the key and algorithm named in
+// the X-KeyId and X-Digest-Alg response headers
+func VerifyResponse(alg Algorithm, secretToken, nonce string, body []byte, digest string) bool {
+	return Verify(alg, secretToken, CanonicalResponse(nonce, body), digest)
+}
+
+// GenerateSecret returns a new random secret key encoded in base64
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(secret), nil
+}
+
+// decodeSignature tells hex from base64 by the length of the encoded
+// signature, which differs for every supported algorithm
+func decodeSignature(encoded string, size int) ([]byte, bool) {
+	if len(encoded) == hex.EncodedLen(size) {
+		signature, err := hex.DecodeString(encoded)
+		return signature, err == nil
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		if signature, err := encoding.DecodeString(encoded); err == nil {
+			return signature, true
+		}
+	}
+
+	return nil, false
 }